@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/dgageot/docker-machine-daemon/daemon/http"
+)
+
+const (
+	defaultHost = "tcp://127.0.0.1:8080"
+)
+
+var flags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "Json configuration file. Flags take precedence",
+	},
+	cli.StringSliceFlag{
+		Name:  "host, H",
+		Usage: "Address to listen on, tcp://[host]:port or unix://path. Can be repeated. Defaults to " + defaultHost,
+	},
+	cli.StringFlag{
+		Name:  "socketmode",
+		Value: "0660",
+		Usage: "File mode of the unix sockets",
+	},
+	cli.StringFlag{
+		Name:  "socketgroup",
+		Usage: "Group that owns the unix sockets",
+	},
+	cli.StringFlag{
+		Name:   "storage-path, s",
+		Usage:  "Directory where the machines are stored. Defaults to ~/.docker/machine",
+		EnvVar: "MACHINE_STORAGE_PATH",
+	},
+	cli.StringFlag{
+		Name:  "log-level",
+		Value: "info",
+		Usage: "Log level, info or debug",
+	},
+	cli.BoolFlag{
+		Name:  "tls",
+		Usage: "Serve https",
+	},
+	cli.BoolFlag{
+		Name:  "tlsverify",
+		Usage: "Serve https and only accept clients with a certificate signed by the CA. Implies --tls",
+	},
+	cli.BoolFlag{
+		Name:  "tlsmachineca",
+		Usage: "Use the CA of the machines instead of a CA dedicated to the daemon. Can't be used with --tlsverify",
+	},
+	cli.StringFlag{
+		Name:  "tlshosts",
+		Value: "localhost,127.0.0.1",
+		Usage: "Comma separated names and ips the server certificate is valid for",
+	},
+	cli.StringFlag{
+		Name:  "tokens",
+		Usage: "Json file with the bearer tokens of the clients and their roles",
+	},
+	cli.StringFlag{
+		Name:  "poll-interval",
+		Value: "10s",
+		Usage: "Interval between two refreshes of the list of machines",
+	},
+	cli.StringFlag{
+		Name:  "lock-timeout",
+		Value: "1m",
+		Usage: "How long a request waits for a busy machine, unless it says otherwise",
+	},
+	cli.StringFlag{
+		Name:  "query-timeout",
+		Value: "10s",
+		Usage: "How long a driver is given to tell the state of a machine",
+	},
+	cli.StringFlag{
+		Name:  "shutdown-timeout",
+		Value: "1m",
+		Usage: "How long the actions in progress are given to complete when the daemon is stopped",
+	},
+}
+
+// config holds the raw values of the flags or of the configuration file.
+type config struct {
+	Hosts           []string
+	SocketMode      string
+	SocketGroup     string
+	StoragePath     string
+	LogLevel        string
+	TLS             bool
+	TLSVerify       bool
+	TLSMachineCA    bool
+	TLSHosts        string
+	Tokens          string
+	PollInterval    string
+	LockTimeout     string
+	QueryTimeout    string
+	ShutdownTimeout string
+}
+
+// settings are the validated values of the configuration.
+type settings struct {
+	Hosts           []string
+	SocketMode      os.FileMode
+	SocketGroup     string
+	StoragePath     string
+	LogLevel        string
+	TLS             bool
+	TLSVerify       bool
+	TLSMachineCA    bool
+	TLSHosts        []string
+	Tokens          string
+	PollInterval    time.Duration
+	LockTimeout     time.Duration
+	QueryTimeout    time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// loadSettings reads the flags and the configuration file, and validates them.
+func loadSettings(c *cli.Context) (*settings, error) {
+	flagConfig := &config{
+		Hosts:           c.GlobalStringSlice("host"),
+		SocketMode:      c.GlobalString("socketmode"),
+		SocketGroup:     c.GlobalString("socketgroup"),
+		StoragePath:     c.GlobalString("storage-path"),
+		LogLevel:        c.GlobalString("log-level"),
+		TLS:             c.GlobalBool("tls"),
+		TLSVerify:       c.GlobalBool("tlsverify"),
+		TLSMachineCA:    c.GlobalBool("tlsmachineca"),
+		TLSHosts:        c.GlobalString("tlshosts"),
+		Tokens:          c.GlobalString("tokens"),
+		PollInterval:    c.GlobalString("poll-interval"),
+		LockTimeout:     c.GlobalString("lock-timeout"),
+		QueryTimeout:    c.GlobalString("query-timeout"),
+		ShutdownTimeout: c.GlobalString("shutdown-timeout"),
+	}
+
+	if path := c.GlobalString("config"); path != "" {
+		fileConfig, err := loadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+
+		flagConfig.merge(fileConfig, func(names ...string) bool {
+			for _, name := range names {
+				if c.GlobalIsSet(name) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	return flagConfig.validate()
+}
+
+func loadConfig(path string) (*config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file: %s", err)
+	}
+
+	c := &config{}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// merge takes the values of the configuration file for the flags that
+// were not given on the command line.
+func (c *config) merge(file *config, isSet func(names ...string) bool) {
+	if !isSet("host", "H") && len(file.Hosts) > 0 {
+		c.Hosts = file.Hosts
+	}
+	if !isSet("socketmode") && file.SocketMode != "" {
+		c.SocketMode = file.SocketMode
+	}
+	if !isSet("socketgroup") && file.SocketGroup != "" {
+		c.SocketGroup = file.SocketGroup
+	}
+	if !isSet("storage-path", "s") && file.StoragePath != "" {
+		c.StoragePath = file.StoragePath
+	}
+	if !isSet("log-level") && file.LogLevel != "" {
+		c.LogLevel = file.LogLevel
+	}
+	if !isSet("tls") && file.TLS {
+		c.TLS = true
+	}
+	if !isSet("tlsverify") && file.TLSVerify {
+		c.TLSVerify = true
+	}
+	if !isSet("tlsmachineca") && file.TLSMachineCA {
+		c.TLSMachineCA = true
+	}
+	if !isSet("tlshosts") && file.TLSHosts != "" {
+		c.TLSHosts = file.TLSHosts
+	}
+	if !isSet("tokens") && file.Tokens != "" {
+		c.Tokens = file.Tokens
+	}
+	if !isSet("poll-interval") && file.PollInterval != "" {
+		c.PollInterval = file.PollInterval
+	}
+	if !isSet("lock-timeout") && file.LockTimeout != "" {
+		c.LockTimeout = file.LockTimeout
+	}
+	if !isSet("query-timeout") && file.QueryTimeout != "" {
+		c.QueryTimeout = file.QueryTimeout
+	}
+	if !isSet("shutdown-timeout") && file.ShutdownTimeout != "" {
+		c.ShutdownTimeout = file.ShutdownTimeout
+	}
+}
+
+func (c *config) validate() (*settings, error) {
+	s := &settings{
+		Hosts:        c.Hosts,
+		SocketGroup:  c.SocketGroup,
+		StoragePath:  c.StoragePath,
+		LogLevel:     c.LogLevel,
+		TLS:          c.TLS || c.TLSVerify,
+		TLSVerify:    c.TLSVerify,
+		TLSMachineCA: c.TLSMachineCA,
+		TLSHosts:     strings.Split(c.TLSHosts, ","),
+		Tokens:       c.Tokens,
+	}
+
+	// The CA of the machines also signs the certificates of their engines,
+	// which can then authenticate as clients. Whoever controls a machine
+	// would control the daemon.
+	if s.TLSVerify && s.TLSMachineCA {
+		return nil, fmt.Errorf("Invalid tls options: --tlsverify can't be used with --tlsmachineca")
+	}
+
+	if len(s.Hosts) == 0 {
+		s.Hosts = []string{defaultHost}
+	}
+	for _, host := range s.Hosts {
+		if _, _, err := http.ParseAddress(host); err != nil {
+			return nil, err
+		}
+	}
+
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid socket mode %q: should be an octal file mode like 0660", c.SocketMode)
+	}
+	s.SocketMode = os.FileMode(mode)
+
+	if s.StoragePath != "" {
+		if info, err := os.Stat(s.StoragePath); err == nil && !info.IsDir() {
+			return nil, fmt.Errorf("Invalid storage path %q: not a directory", s.StoragePath)
+		}
+	}
+
+	if s.LogLevel != "info" && s.LogLevel != "debug" {
+		return nil, fmt.Errorf("Invalid log level %q: supported levels are info and debug", s.LogLevel)
+	}
+
+	if s.Tokens != "" {
+		if _, err := os.Stat(s.Tokens); err != nil {
+			return nil, fmt.Errorf("Invalid tokens file: %s", err)
+		}
+	}
+
+	for name, duration := range map[string]struct {
+		value     string
+		target    *time.Duration
+		allowZero bool
+	}{
+		"poll interval":    {c.PollInterval, &s.PollInterval, false},
+		"lock timeout":     {c.LockTimeout, &s.LockTimeout, true},
+		"query timeout":    {c.QueryTimeout, &s.QueryTimeout, false},
+		"shutdown timeout": {c.ShutdownTimeout, &s.ShutdownTimeout, true},
+	} {
+		value, err := time.ParseDuration(duration.value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s %q: should be a duration like 10s or 1m", name, duration.value)
+		}
+		if value < 0 || (value == 0 && !duration.allowZero) {
+			return nil, fmt.Errorf("Invalid %s %q: should be greater than zero", name, duration.value)
+		}
+		*duration.target = value
+	}
+
+	return s, nil
+}
+
+// unprotected lists the tcp addresses, other than the loopback ones, that
+// are served without authenticating the clients.
+func (s *settings) unprotected() []string {
+	if s.TLSVerify || s.Tokens != "" {
+		return nil
+	}
+
+	addresses := []string{}
+	for _, host := range s.Hosts {
+		proto, addr, _ := http.ParseAddress(host)
+		if proto != "tcp" {
+			continue
+		}
+
+		hostname, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			hostname = addr
+		}
+		if hostname == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+			continue
+		}
+
+		addresses = append(addresses, host)
+	}
+
+	return addresses
+}
@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
+	"github.com/docker/machine/libmachine/mcnutils"
+)
+
+const (
+	bits = 2048
+)
+
+// Certificates are the files used to serve https and to verify the
+// certificates of the clients.
+type Certificates struct {
+	CACert     string
+	CAKey      string
+	ServerCert string
+	ServerKey  string
+}
+
+// Dir is where the certificates of the daemon are stored.
+func Dir() string {
+	return filepath.Join(mcndirs.GetBaseDir(), "daemon", "certs")
+}
+
+// Bootstrap generates the missing certificates. The CA is either the one
+// used for the machines or a CA dedicated to the daemon. The server
+// certificate is generated again when it's not signed by this CA or
+// when it's not valid for exactly the given hosts.
+func Bootstrap(useMachineCA bool, hosts []string) (*Certificates, error) {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	caDir := dir
+	if useMachineCA {
+		caDir = mcndirs.GetMachineCertDir()
+	}
+
+	if err := cert.BootstrapCertificates(&auth.Options{
+		CertDir:          caDir,
+		CaCertPath:       filepath.Join(caDir, "ca.pem"),
+		CaPrivateKeyPath: filepath.Join(caDir, "ca-key.pem"),
+		ClientCertPath:   filepath.Join(caDir, "cert.pem"),
+		ClientKeyPath:    filepath.Join(caDir, "key.pem"),
+	}); err != nil {
+		return nil, err
+	}
+
+	certificates := &Certificates{
+		CACert:     filepath.Join(caDir, "ca.pem"),
+		CAKey:      filepath.Join(caDir, "ca-key.pem"),
+		ServerCert: filepath.Join(dir, "server.pem"),
+		ServerKey:  filepath.Join(dir, "server-key.pem"),
+	}
+
+	signed, _ := certificates.isSigned(certificates.ServerCert)
+	if !signed || !hasHosts(certificates.ServerCert, hosts) {
+		org := mcnutils.GetUsername() + ".<daemon>"
+		if err := cert.GenerateCert(hosts, certificates.ServerCert, certificates.ServerKey, certificates.CACert, certificates.CAKey, org, bits); err != nil {
+			return nil, fmt.Errorf("Generating server certificate failed: %s", err)
+		}
+	}
+
+	return certificates, nil
+}
+
+// TLSConfig configures https. With verifyClients, only the clients with a
+// certificate signed by the CA are accepted.
+func (c *Certificates) TLSConfig(verifyClients bool) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(c.ServerCert, c.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if verifyClients {
+		caCert, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("Invalid CA certificate " + c.CACert)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// GenerateClientCert writes to a directory a client certificate, its key and
+// the CA certificate. They are the files a docker client would expect.
+func (c *Certificates) GenerateClientCert(name string, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if err := cert.GenerateCert([]string{""}, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), c.CACert, c.CAKey, name, bits); err != nil {
+		return fmt.Errorf("Generating client certificate failed: %s", err)
+	}
+
+	caCert, err := ioutil.ReadFile(c.CACert)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caCert, 0644)
+}
+
+func (c *Certificates) isSigned(path string) (bool, error) {
+	certificate, err := readCertificate(path)
+	if err != nil {
+		return false, err
+	}
+
+	ca, err := readCertificate(c.CACert)
+	if err != nil {
+		return false, err
+	}
+
+	return certificate.CheckSignatureFrom(ca) == nil, nil
+}
+
+// hasHosts tells if a certificate is valid for exactly the given hosts.
+func hasHosts(path string, hosts []string) bool {
+	certificate, err := readCertificate(path)
+	if err != nil {
+		return false
+	}
+
+	names := make(map[string]bool)
+	for _, name := range certificate.DNSNames {
+		names[name] = true
+	}
+	for _, ip := range certificate.IPAddresses {
+		names[ip.String()] = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		wanted[host] = true
+	}
+
+	if len(names) != len(wanted) {
+		return false
+	}
+	for name := range wanted {
+		if !names[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("Invalid certificate " + path)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -1,6 +1,9 @@
 package daemon
 
-// Starter can be started on a given port.
+// Starter can be started on given addresses, like tcp://:8080
+// or unix:///var/run/docker-machine-daemon.sock, and stopped.
 type Starter interface {
-	Start(port int) error
+	Start(addresses ...string) error
+	// Stop stops accepting connections. Start then returns without error.
+	Stop() error
 }
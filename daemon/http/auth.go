@@ -0,0 +1,98 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/dgageot/docker-machine-daemon/handlers"
+)
+
+// Identity is the owner of a token and the role given to them.
+type Identity struct {
+	Name  string
+	Token string
+	Role  string
+}
+
+// Tokens are the bearer tokens accepted by the daemon.
+type Tokens []Identity
+
+// LoadTokens reads the tokens from a json file, a list of identities.
+func LoadTokens(path string) (Tokens, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens Tokens
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("Invalid tokens file %s: %s", path, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Invalid tokens file %s: it contains no token", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, identity := range tokens {
+		if identity.Name == "" || identity.Token == "" {
+			return nil, fmt.Errorf("Invalid tokens file %s: each token requires a Name and a Token", path)
+		}
+		if !handlers.IsRole(identity.Role) {
+			return nil, fmt.Errorf("Invalid tokens file %s: unknown role %q for %s", path, identity.Role, identity.Name)
+		}
+		if seen[identity.Token] {
+			return nil, fmt.Errorf("Invalid tokens file %s: the token of %s is used twice", path, identity.Name)
+		}
+		seen[identity.Token] = true
+	}
+
+	return tokens, nil
+}
+
+// authenticate finds who the bearer token of a request belongs to.
+func (tokens Tokens) authenticate(request *http.Request) (Identity, bool) {
+	header := request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Identity{}, false
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for _, identity := range tokens {
+		if subtle.ConstantTimeCompare(token, []byte(identity.Token)) == 1 {
+			return identity, true
+		}
+	}
+
+	return Identity{}, false
+}
+
+// authorize checks that a request is made with a token allowed to call the mapping.
+// Without tokens, every request is authorized as admin.
+func (tokens Tokens) authorize(mapping handlers.Mapping, request *http.Request) (Identity, int, *handlers.Error) {
+	if tokens == nil {
+		return Identity{Role: handlers.RoleAdmin}, http.StatusOK, nil
+	}
+
+	identity, found := tokens.authenticate(request)
+	if !found {
+		return Identity{Name: "anonymous"}, http.StatusUnauthorized, &handlers.Error{
+			Code:    "Unauthorized",
+			Message: "Requires a valid bearer token",
+		}
+	}
+
+	required := mapping.RequiredRole()
+	if !handlers.HasRole(identity.Role, required) {
+		return identity, http.StatusForbidden, &handlers.Error{
+			Code:    "Forbidden",
+			Message: fmt.Sprintf("%s is %s but the action requires %s", identity.Name, identity.Role, required),
+			Name:    identity.Name,
+		}
+	}
+
+	return identity, http.StatusOK, nil
+}
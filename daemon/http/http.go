@@ -1,7 +1,14 @@
 package http
 
 import (
-	"fmt"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
 
 	"net/http"
 
@@ -12,44 +19,233 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Options configure the http daemon.
+type Options struct {
+	// TLSConfig enables https
+	TLSConfig *tls.Config
+	// Tokens enables the authentication of the clients
+	Tokens Tokens
+	// SocketMode is the file mode of the unix sockets
+	SocketMode os.FileMode
+	// SocketGroup is the group, name or id, that owns the unix sockets
+	SocketGroup string
+}
+
 type httpDaemon struct {
-	mappings []handlers.Mapping
+	sync.Mutex
+	mappings  []handlers.Mapping
+	options   Options
+	server    *http.Server
+	listeners []net.Listener
+	stopped   bool
 }
 
-// NewDaemon create a new http daemon with given mappings.
-func NewDaemon(mappings ...handlers.Mapping) daemon.Starter {
+// NewDaemon create a new http daemon with given options and mappings.
+func NewDaemon(options Options, mappings ...handlers.Mapping) daemon.Starter {
 	return &httpDaemon{
 		mappings: mappings,
+		options:  options,
 	}
 }
 
-// Start starts the http daemon.
-func (d *httpDaemon) Start(port int) error {
+// Start starts the http daemon on each address. It fails if one of the
+// addresses can't be listened on.
+func (d *httpDaemon) Start(addresses ...string) error {
 	r := mux.NewRouter()
 
 	for _, mapping := range d.mappings {
-		r.NewRoute().Path(mapping.Url).Handler(toHandler(mapping.Handler)).Methods(mapping.Method)
+		r.NewRoute().Path(mapping.Url).Handler(d.toHandler(mapping)).Methods(mapping.Method)
+	}
+
+	listeners := []net.Listener{}
+	for _, address := range addresses {
+		listener, err := d.listen(address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	server := &http.Server{Handler: r}
+
+	d.Lock()
+	if d.stopped {
+		d.Unlock()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return nil
+	}
+	d.server = server
+	d.listeners = listeners
+	d.Unlock()
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errs <- server.Serve(listener)
+		}(listener)
+	}
+
+	err := <-errs
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.stopped {
+		return nil
+	}
+	return err
+}
+
+// Stop closes the listeners and the keep-alive connections, once their
+// current request is served. The requests in progress are not interrupted.
+// A daemon stopped before it is started doesn't listen at all.
+func (d *httpDaemon) Stop() error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.stopped = true
+	if d.server != nil {
+		d.server.SetKeepAlivesEnabled(false)
 	}
 
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), r)
+	var err error
+	for _, listener := range d.listeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return err
 }
 
-func toHandler(handler handlers.Handler) http.HandlerFunc {
+func (d *httpDaemon) toHandler(mapping handlers.Mapping) http.HandlerFunc {
 	return func(response http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		// The daemon waits for the whole request, streaming included, before exiting
+		end, err := handlers.BeginOperation(request.Method+" "+request.URL.Path, vars["name"])
+		if err != nil {
+			status, body := handlers.ToError(err, vars["name"])
+			response.Header().Set("Connection", "close")
+			writeJSON(response, status, body)
+			return
+		}
+		defer end()
+
+		identity, status, denied := d.options.Tokens.authorize(mapping, request)
+		if denied != nil {
+			log.Printf("Denied %s %s to %s: %s", request.Method, request.URL.Path, identity.Name, denied.Message)
+			if status == http.StatusUnauthorized {
+				response.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			writeJSON(response, status, denied)
+			return
+		}
+		vars[handlers.CallerRole] = identity.Role
+		vars[handlers.ContentType] = request.Header.Get("Content-Type")
+
 		if err := request.ParseForm(); err != nil {
 			log.Print(err)
-			response.WriteHeader(500)
+			writeJSON(response, http.StatusBadRequest, handlers.Error{
+				Code:    "InvalidRequest",
+				Message: err.Error(),
+				Name:    vars["name"],
+			})
+			return
+		}
+
+		// Browsers resume event streams with a header
+		if lastEventID := request.Header.Get("Last-Event-ID"); lastEventID != "" && request.Form.Get("lastEventId") == "" {
+			request.Form.Set("lastEventId", lastEventID)
+		}
+
+		async := mapping.CanRunAsJob() && (mapping.Background || request.Form.Get("async") == "true")
+
+		var body io.Reader = request.Body
+		if async {
+			// The job outlives the request so it can't read from the connection
+			content, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				log.Print(err)
+				writeJSON(response, http.StatusBadRequest, handlers.Error{
+					Code:    "InvalidRequest",
+					Message: err.Error(),
+					Name:    vars["name"],
+				})
+				return
+			}
+			body = bytes.NewReader(content)
+		}
+
+		handler := handlers.WithApi(mapping.Handler, vars, request.Form, body)
+		if mapping.IsMutating() {
+			handler = handlers.WithLock(handler, vars, request.Form)
+			handler = handlers.WithRefresh(handler, vars)
+		}
+
+		if async {
+			job, err := handlers.StartJob(mapping.Method+" "+request.URL.Path, vars["name"], mapping.RequiredRole(), handler)
+			if err != nil {
+				status, body := handlers.ToError(err, vars["name"])
+				writeJSON(response, status, body)
+				return
+			}
+
+			response.Header().Set("Location", "/jobs/"+job.ID)
+			writeJSON(response, http.StatusAccepted, job)
 			return
 		}
 
-		output, err := handlers.ToJson(handlers.WithApi(handler, mux.Vars(request), request.PostForm))
+		result, err := handler()
 		if err != nil {
 			log.Print(err)
-			response.WriteHeader(500)
+			status, body := handlers.ToError(err, vars["name"])
+			writeJSON(response, status, body)
+			return
+		}
+
+		if streamer, ok := result.(handlers.Streamer); ok {
+			response.Header().Set("Content-Type", streamer.ContentType())
+			response.Header().Set("Cache-Control", "no-cache")
+			if err := streamer.Stream(&flushWriter{response}); err != nil {
+				log.Print(err)
+			}
 			return
 		}
 
-		response.Header().Set("Content-Type", "application/json")
-		response.Write(output)
+		writeJSON(response, http.StatusOK, result)
+	}
+}
+
+// flushWriter sends each write to the client right away.
+type flushWriter struct {
+	response http.ResponseWriter
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.response.Write(p)
+	if flusher, ok := w.response.(http.Flusher); ok {
+		flusher.Flush()
 	}
+
+	return n, err
+}
+
+func writeJSON(response http.ResponseWriter, status int, body interface{}) {
+	output, err := json.Marshal(body)
+	if err != nil {
+		log.Print(err)
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	response.Write(output)
 }
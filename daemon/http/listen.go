@@ -0,0 +1,142 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long to try to reach a process listening on an existing socket
+const socketDialTimeout = 1 * time.Second
+
+// ParseAddress splits an address into a protocol and an address. Addresses
+// without a protocol are tcp addresses.
+func ParseAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		path := strings.TrimPrefix(address, "unix://")
+		if path == "" {
+			return "", "", fmt.Errorf("Invalid address %q: requires a socket path", address)
+		}
+		return "unix", path, nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.Contains(address, "://"):
+		return "", "", fmt.Errorf("Invalid address %q: supported protocols are tcp and unix", address)
+	default:
+		return "tcp", address, nil
+	}
+}
+
+// listen listens on an address. Tcp listeners serve https if it's enabled.
+// Unix sockets rely on file permissions instead.
+func (d *httpDaemon) listen(address string) (net.Listener, error) {
+	proto, addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if proto == "unix" {
+		return listenUnix(addr, d.options.SocketMode, d.options.SocketGroup)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.options.TLSConfig != nil {
+		listener = tls.NewListener(listener, d.options.TLSConfig)
+	}
+
+	return listener, nil
+}
+
+// listenUnix creates the socket in a private directory, where it gets its
+// permissions, then moves it into place. It's never reachable with the
+// permissions given by the umask.
+func listenUnix(path string, mode os.FileMode, group string) (net.Listener, error) {
+	// A socket left by a previous run is replaced, anything else is kept
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Can't listen on %s: the file exists and is not a socket", path)
+		}
+
+		if conn, err := net.DialTimeout("unix", path, socketDialTimeout); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Can't listen on %s: another process is listening on it", path)
+		}
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".socket")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		if err := os.Chown(tmpPath, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &unixListener{listener, path}, nil
+}
+
+// unixListener removes the socket when it's closed, since it was moved
+// away from where it was created.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+
+	return err
+}
+
+// lookupGroup finds the id of a group given by name or by id.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(g.Gid)
+}
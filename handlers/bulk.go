@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/docker/machine/libmachine"
+)
+
+const (
+	defaultParallelism = 4
+)
+
+// Actions that can be run on a set of machines.
+var bulkActions = map[string]HandlerFunc{
+	"start":   Start,
+	"stop":    Stop,
+	"restart": Restart,
+	"kill":    Kill,
+	"remove":  Remove,
+}
+
+// BulkResult is the outcome of an action on one machine of a set.
+type BulkResult struct {
+	Name   string
+	Result interface{} `json:",omitempty"`
+	Error  *Error      `json:",omitempty"`
+}
+
+// Bulk runs an action on a list of machines given with machine=... or on the
+// machines selected by the same filters as the list. A machine that fails
+// doesn't stop the others.
+func Bulk(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	action, present := bulkActions[args["action"]]
+	if !present {
+		return nil, errInvalidParameter{"action", args["action"], errUnknownAction}
+	}
+
+	parallelism := defaultParallelism
+	if value := formValue(form, "parallelism"); value != "" {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errInvalidParameter{"parallelism", value, err}
+		}
+		if i < 1 {
+			return nil, errInvalidParameter{"parallelism", value, errNotPositive}
+		}
+		parallelism = i
+	}
+
+	names, err := selectMachines(api, form)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BulkResult, len(names))
+	slots := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// Each machine gets its own client since libmachine is not thread safe.
+			machineArgs := map[string]string{"name": name}
+			handler := WithApi(action, machineArgs, form, nil)
+			handler = WithLock(handler, machineArgs, form)
+			handler = WithRefresh(handler, machineArgs)
+
+			results[i].Name = name
+			result, err := handler()
+			if err != nil {
+				_, machineError := ToError(err, name)
+				results[i].Error = &machineError
+			} else {
+				results[i].Result = result
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+func selectMachines(api libmachine.API, form map[string][]string) ([]string, error) {
+	names := form["machine"]
+
+	filters, err := parseFilters(form)
+	if err != nil {
+		return nil, err
+	}
+
+	if filters.isEmpty() {
+		if len(names) == 0 {
+			return nil, errRequireMachineSelection
+		}
+
+		return names, nil
+	}
+
+	timeout, err := lockTimeout(form)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := listItems(api, timeout, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range filters.apply(items) {
+		if !containsString(names, item.Name) {
+			names = append(names, item.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
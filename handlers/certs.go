@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
+)
+
+// MachineCAInUse is set when the daemon serves https with the CA of the
+// machines. That CA can't be generated again while the daemon runs.
+var MachineCAInUse bool
+
+// Certificates tells when the certificates of a machine expire.
+type Certificates struct {
+	Success
+	CACertExpiry     time.Time
+	ClientCertExpiry time.Time
+	ServerCertExpiry time.Time
+	// Outdated lists the other machines sharing the CA after it is
+	// generated again. Their certificates must be regenerated too.
+	Outdated []string `json:",omitempty"`
+}
+
+// RegenerateCerts generates new certificates for the Docker engine of a
+// Docker Machine. With ca=true, the CA and the client certificate, shared
+// by all the machines, are generated again first.
+func RegenerateCerts(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	authOptions := h.AuthOptions()
+	if authOptions == nil {
+		return nil, errNoCertificates
+	}
+
+	regenerateAll := formValue(form, "ca") == "true"
+	if regenerateAll {
+		if err := regenerateCAAndConfigure(h.ConfigureAuth, authOptions); err != nil {
+			return nil, err
+		}
+	} else if err := h.ConfigureAuth(); err != nil {
+		return nil, err
+	}
+
+	if err := api.Save(h); err != nil {
+		return nil, err
+	}
+
+	certificates := Certificates{Success: Success{"regenerated", h.Name}}
+	for path, expiry := range map[string]*time.Time{
+		authOptions.CaCertPath:     &certificates.CACertExpiry,
+		authOptions.ClientCertPath: &certificates.ClientCertExpiry,
+		authOptions.ServerCertPath: &certificates.ServerCertExpiry,
+	} {
+		if *expiry, err = certificateExpiry(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if regenerateAll {
+		if certificates.Outdated, err = sharingCA(api, h.Name, authOptions.CaCertPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return certificates, nil
+}
+
+// regenerateCAAndConfigure replaces the CA shared by the machines, then
+// configures a machine with it. The store is locked so that no other machine
+// is created or configured meanwhile. The previous CA is restored if the
+// machine can't be configured.
+func regenerateCAAndConfigure(configureAuth func() error, authOptions *auth.Options) error {
+	if MachineCAInUse {
+		return errMachineCAInUse
+	}
+
+	unlock, err := locks.writeStore(DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	swap, err := regenerateCA(authOptions)
+	if err != nil {
+		return errWithCause{"Error generating the CA", err}
+	}
+
+	if err := configureAuth(); err != nil {
+		swap.restore()
+		return err
+	}
+	swap.discard()
+
+	return nil
+}
+
+// caSwap is a CA that was generated again, with the backups of the previous one.
+type caSwap struct {
+	dir     string
+	targets []string
+	backups []string
+}
+
+// restore puts back the files that were backed up.
+func (s *caSwap) restore() {
+	for i, backup := range s.backups {
+		if backup != "" {
+			os.Rename(backup, s.targets[i])
+		}
+	}
+	s.discard()
+}
+
+func (s *caSwap) discard() {
+	os.RemoveAll(s.dir)
+}
+
+// regenerateCA generates a new CA and client certificate, the same way they
+// are the first time, in a temporary directory. They then replace the current
+// ones, which are backed up in the same directory.
+func regenerateCA(authOptions *auth.Options) (*caSwap, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(authOptions.CaCertPath), ".regenerate-ca")
+	if err != nil {
+		return nil, err
+	}
+
+	generated := *authOptions
+	generated.CertDir = dir
+	generated.CaCertPath = filepath.Join(dir, "ca.pem")
+	generated.CaPrivateKeyPath = filepath.Join(dir, "ca-key.pem")
+	generated.ClientCertPath = filepath.Join(dir, "cert.pem")
+	generated.ClientKeyPath = filepath.Join(dir, "key.pem")
+
+	swap := &caSwap{
+		dir:     dir,
+		targets: []string{authOptions.CaCertPath, authOptions.CaPrivateKeyPath, authOptions.ClientCertPath, authOptions.ClientKeyPath},
+		backups: make([]string, 4),
+	}
+
+	if err := cert.BootstrapCertificates(&generated); err != nil {
+		swap.discard()
+		return nil, err
+	}
+
+	sources := []string{generated.CaCertPath, generated.CaPrivateKeyPath, generated.ClientCertPath, generated.ClientKeyPath}
+
+	for i, target := range swap.targets {
+		backup := filepath.Join(dir, fmt.Sprintf("backup-%d", i))
+		if err := os.Rename(target, backup); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			swap.restore()
+			return nil, err
+		}
+		swap.backups[i] = backup
+	}
+
+	for i, source := range sources {
+		if err := os.Rename(source, swap.targets[i]); err != nil {
+			swap.restore()
+			return nil, err
+		}
+	}
+
+	return swap, nil
+}
+
+// sharingCA lists the machines, other than the named one, that use the given CA.
+func sharingCA(api libmachine.API, name string, caCertPath string) ([]string, error) {
+	names, err := api.List()
+	if err != nil {
+		return nil, err
+	}
+
+	sharing := []string{}
+	for _, other := range names {
+		if other == name {
+			continue
+		}
+
+		h, err := api.Load(other)
+		if err != nil {
+			continue
+		}
+		if authOptions := h.AuthOptions(); authOptions != nil && authOptions.CaCertPath == caCertPath {
+			sharing = append(sharing, other)
+		}
+	}
+
+	return sharing, nil
+}
+
+func certificateExpiry(path string) (time.Time, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return time.Time{}, errors.New("Invalid certificate " + path)
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return certificate.NotAfter, nil
+}
@@ -3,57 +3,227 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"path/filepath"
-
 	"strconv"
 
 	"github.com/codegangsta/cli"
+	"github.com/docker/machine/commands"
 	"github.com/docker/machine/commands/mcndirs"
 	"github.com/docker/machine/libmachine"
 	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
 	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
 	"github.com/docker/machine/libmachine/drivers/rpc"
 	"github.com/docker/machine/libmachine/engine"
 	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/mcnerror"
 	"github.com/docker/machine/libmachine/mcnflag"
 	"github.com/docker/machine/libmachine/swarm"
-	"github.com/docker/machine/commands"
 )
 
-// Create creates a Docker Machine
-func Create(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+// Create creates a Docker Machine. Options are read either from the form
+// or from a json document sent as the body of the request.
+func Create(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
 	name, present := args["name"]
 	if !present {
 		return nil, errRequireMachineName
 	}
 
-	drivers, present := form["driver"]
-	if !present || len(drivers) != 1 {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// A form sent as the body is already part of the form
+	if len(content) > 0 {
+		contentType := args[ContentType]
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			return nil, errInvalidParameter{"Content-Type", contentType, errUnsupportedContentType}
+		}
+	}
+
+	profileName := formValue(form, "profile")
+
+	var opts createOptions
+	switch {
+	case len(content) > 0 && profileName != "":
+		return nil, errInvalidParameter{"profile", profileName, errProfileWithJSON}
+	case len(content) > 0:
+		opts, err = parseCreateRequest(content)
+		if err != nil {
+			return nil, err
+		}
+	case profileName != "":
+		profile, err := loadProfile(profileName)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = formOptions(applyProfile(profile, form))
+	default:
+		opts = formOptions(form)
+	}
+
+	if opts.driverName() == "" {
 		return nil, errRequireDriverName
 	}
 
-	if err := createMachine(api, name, drivers[0], form); err != nil {
+	if formValue(form, "dryRun") == "true" {
+		return validateMachine(api, name, opts)
+	}
+
+	policy := formValue(form, "onFailure")
+	switch policy {
+	case "":
+		policy = failureKeep
+	case failureKeep, failureRemove, failureTeardown:
+	default:
+		return nil, errInvalidParameter{"onFailure", policy, errUnknownFailurePolicy}
+	}
+
+	if err := createMachine(api, name, opts, policy); err != nil {
 		return nil, err
 	}
 
 	return Success{"created", name}, nil
 }
 
-func createMachine(api libmachine.API, name string, driver string, form map[string][]string) error {
+const (
+	// Keep whatever was created
+	failureKeep = "keep"
+	// Remove the machine from the store only
+	failureRemove = "remove"
+	// Remove the machine with its driver, then from the store
+	failureTeardown = "teardown"
+)
+
+// createOptions gives the options of a new machine.
+type createOptions interface {
+	driverName() string
+	hostOptions(name string) (*host.Options, error)
+	driverOptions(mcnflags []mcnflag.Flag) (drivers.DriverOptions, error)
+}
+
+// formOptions reads the options from a form, the same way docker-machine reads its flags.
+type formOptions map[string][]string
+
+func (form formOptions) driverName() string {
+	return formValue(form, "driver")
+}
+
+func (form formOptions) hostOptions(name string) (*host.Options, error) {
+	return newHostOptions(name, &globalFlags{form}), nil
+}
+
+func (form formOptions) driverOptions(mcnflags []mcnflag.Flag) (drivers.DriverOptions, error) {
+	return parseFlags(form, mcnflags, commands.SharedCreateFlags)
+}
+
+func createMachine(api libmachine.API, name string, opts createOptions, policy string) error {
+	h, _, errs := prepareMachine(api, name, opts)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	if err := bootstrapCertificates(h); err != nil {
+		return err
+	}
+
+	if err := api.Create(h); err != nil {
+		return cleanupMachine(api, h, policy, err)
+	}
+
+	if err := api.Save(h); err != nil {
+		return cleanupMachine(api, h, policy, fmt.Errorf("Error attempting to save store: %s", err))
+	}
+
+	return nil
+}
+
+// bootstrapCertificates generates the CA shared by the machines, if it's
+// missing, before libmachine's Create finds it. The store is locked so that
+// concurrent creations don't generate it twice.
+func bootstrapCertificates(h *host.Host) error {
+	unlock, err := locks.writeStore(DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := cert.BootstrapCertificates(h.AuthOptions()); err != nil {
+		return fmt.Errorf("Error generating certificates: %s", err)
+	}
+
+	return nil
+}
+
+// CreateFailure tells what was done with a machine that failed to be created.
+type CreateFailure struct {
+	Policy       string
+	Cleanup      string
+	CleanupError string `json:",omitempty"`
+}
+
+// cleanupMachine applies the failure policy to a machine that failed to be created.
+func cleanupMachine(api libmachine.API, h *host.Host, policy string, cause error) error {
+	failure := CreateFailure{
+		Policy:  policy,
+		Cleanup: "none",
+	}
+
+	// Nothing was saved or created if the pre-create checks failed
+	exists, err := api.Exists(h.Name)
+	if err != nil {
+		failure.Cleanup = "failed"
+		failure.CleanupError = err.Error()
+		return errCreateFailed{cause, failure}
+	}
+	if !exists || policy == failureKeep {
+		return errCreateFailed{cause, failure}
+	}
+
+	if policy == failureTeardown {
+		if err := h.Driver.Remove(); err != nil {
+			failure.Cleanup = "failed"
+			failure.CleanupError = err.Error()
+			return errCreateFailed{cause, failure}
+		}
+	}
+
+	if err := api.Remove(h.Name); err != nil {
+		failure.Cleanup = "failed"
+		failure.CleanupError = err.Error()
+		return errCreateFailed{cause, failure}
+	}
+
+	failure.Cleanup = "removed"
+	return errCreateFailed{cause, failure}
+}
+
+// prepareMachine runs every step of the creation of a machine, up to but
+// excluding the creation itself. Nothing is written to the store. It keeps
+// going after an error, as long as it can, so that all the problems are reported.
+func prepareMachine(api libmachine.API, name string, opts createOptions) (*host.Host, drivers.DriverOptions, errValidation) {
+	errs := errValidation{}
+
 	validName := host.ValidateHostName(name)
 	if !validName {
-		return fmt.Errorf("Error creating machine: %s", mcnerror.ErrInvalidHostname)
+		errs = append(errs, mcnerror.ErrInvalidHostname)
 	}
 
 	exists, err := api.Exists(name)
 	if err != nil {
-		return fmt.Errorf("Error checking if host exists: %s", err)
+		errs = append(errs, errWithCause{"Error checking if host exists", err})
 	}
 	if exists {
-		return mcnerror.ErrHostAlreadyExists{
+		errs = append(errs, mcnerror.ErrHostAlreadyExists{
 			Name: name,
-		}
+		})
 	}
 
 	rawDriver, err := json.Marshal(&drivers.BaseDriver{
@@ -61,19 +231,67 @@ func createMachine(api libmachine.API, name string, driver string, form map[stri
 		StorePath:   mcndirs.GetBaseDir(),
 	})
 	if err != nil {
-		return fmt.Errorf("Error attempting to marshal bare driver data: %s", err)
+		return nil, nil, append(errs, fmt.Errorf("Error attempting to marshal bare driver data: %s", err))
 	}
 
-	h, err := api.NewHost(driver, rawDriver)
+	h, err := api.NewHost(opts.driverName(), rawDriver)
+	if _, notFound := err.(localbinary.ErrPluginBinaryNotFound); notFound {
+		return nil, nil, append(errs, errDriverNotFound{opts.driverName()})
+	}
 	if err != nil {
-		return err
+		return nil, nil, append(errs, err)
+	}
+
+	h.HostOptions, err = opts.hostOptions(name)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	driverOpts, err := opts.driverOptions(h.Driver.GetCreateFlags())
+	if err != nil {
+		return h, nil, append(errs, err)
+	}
+
+	if err := h.Driver.SetConfigFromFlags(driverOpts); err != nil {
+		errs = append(errs, fmt.Errorf("Error setting machine configuration from flags provided: %s", err))
+	}
+
+	return h, driverOpts, errs
+}
+
+// DryRun shows the options a machine would be created with.
+type DryRun struct {
+	Name          string
+	Driver        string
+	DriverOptions map[string]interface{}
+	HostOptions   *host.Options
+}
+
+// validateMachine checks that a machine could be created, without creating it.
+func validateMachine(api libmachine.API, name string, opts createOptions) (interface{}, error) {
+	h, driverOpts, errs := prepareMachine(api, name, opts)
+	if len(errs) == 0 {
+		if err := h.Driver.PreCreateCheck(); err != nil {
+			errs = append(errs, mcnerror.ErrDuringPreCreate{
+				Cause: err,
+			})
+		}
 	}
 
-	globalOpts := globalFlags{
-		flags: form,
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
-	h.HostOptions = &host.Options{
+	return DryRun{
+		Name:          name,
+		Driver:        h.DriverName,
+		DriverOptions: driverOpts.(rpcdriver.RPCFlags).Values,
+		HostOptions:   h.HostOptions,
+	}, nil
+}
+
+func newHostOptions(name string, globalOpts *globalFlags) *host.Options {
+	return &host.Options{
 		AuthOptions: &auth.Options{
 			CertDir:          mcndirs.GetMachineCertDir(),
 			CaCertPath:       filepath.Join(mcndirs.GetMachineCertDir(), "ca.pem"),
@@ -107,26 +325,6 @@ func createMachine(api libmachine.API, name string, driver string, form map[stri
 			IsExperimental: globalOpts.Bool("swarm-experimental"),
 		},
 	}
-
-	mcnFlags := h.Driver.GetCreateFlags()
-	opts, err := parseFlags(form, mcnFlags, commands.SharedCreateFlags)
-	if err != nil {
-		return err
-	}
-
-	if err := h.Driver.SetConfigFromFlags(opts); err != nil {
-		return fmt.Errorf("Error setting machine configuration from flags provided: %s", err)
-	}
-
-	if err := api.Create(h); err != nil {
-		return err
-	}
-
-	if err := api.Save(h); err != nil {
-		return fmt.Errorf("Error attempting to save store: %s", err)
-	}
-
-	return nil
 }
 
 func parseFlags(form map[string][]string, mcnflags []mcnflag.Flag, cliFlags []cli.Flag) (drivers.DriverOptions, error) {
@@ -157,20 +355,22 @@ func parseFlags(form map[string][]string, mcnflags []mcnflag.Flag, cliFlags []cl
 			if present && len(values) == 1 {
 				i, err := strconv.Atoi(values[0])
 				if err != nil {
-					driverOpts.Values[f.Name] = i
+					return nil, errInvalidParameter{f.Name, values[0], err}
 				}
+
+				driverOpts.Values[f.Name] = i
 			}
 		case cli.BoolFlag:
 			driverOpts.Values[f.Name] = false
 
-			value, present := form[f.Name]
-			if present && len(value) == 1 {
-				driverOpts.Values[f.Name] = value[0]
-
-				values, present := form[f.Name]
-				if present && len(values) == 1 {
-					driverOpts.Values[f.Name] = values[0] == "true"
+			values, present := form[f.Name]
+			if present && len(values) == 1 {
+				b, err := parseBool(values[0])
+				if err != nil {
+					return nil, errInvalidParameter{f.Name, values[0], err}
 				}
+
+				driverOpts.Values[f.Name] = b
 			}
 		}
 	}
@@ -192,12 +392,17 @@ func parseFlags(form map[string][]string, mcnflags []mcnflag.Flag, cliFlags []cl
 			case mcnflag.IntFlag:
 				i, err := strconv.Atoi(values[0])
 				if err != nil {
-					return nil, err
+					return nil, errInvalidParameter{f.String(), values[0], err}
 				}
 
 				driverOpts.Values[f.String()] = i
 			case mcnflag.BoolFlag:
-				driverOpts.Values[f.String()] = (values[0] == "true")
+				b, err := parseBool(values[0])
+				if err != nil {
+					return nil, errInvalidParameter{f.String(), values[0], err}
+				}
+
+				driverOpts.Values[f.String()] = b
 			}
 		}
 	}
@@ -231,7 +436,7 @@ func (o *globalFlags) Int(key string) int {
 	value, present := o.flags[key]
 	if present && len(value) > 0 {
 		i, err := strconv.Atoi(value[0])
-		if err != nil {
+		if err == nil {
 			return i
 		}
 	}
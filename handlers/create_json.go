@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/rpc"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// remoteAuthFields are the fields of auth.Options that a client can set.
+var remoteAuthFields = map[string]bool{
+	"CaCertRemotePath":     true,
+	"ServerCertRemotePath": true,
+	"ServerKeyRemotePath":  true,
+	"ServerCertSANs":       true,
+}
+
+const (
+	flagString      = "string"
+	flagStringSlice = "stringSlice"
+	flagInt         = "int"
+	flagBool        = "bool"
+)
+
+// CreateRequest is the json document that describes a machine to create.
+// Engine, Swarm and Auth mirror engine.Options, swarm.Options and auth.Options.
+// Only the fields of auth.Options that don't point to local files can be set.
+type CreateRequest struct {
+	Driver  string
+	Options map[string]interface{}
+	Engine  map[string]json.RawMessage
+	Swarm   map[string]json.RawMessage
+	Auth    map[string]json.RawMessage
+}
+
+func parseCreateRequest(content []byte) (*CreateRequest, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, errInvalidParameter{"body", string(content), err}
+	}
+
+	request := &CreateRequest{}
+	if err := decodeFields(fields, request, ""); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+func (r *CreateRequest) driverName() string {
+	return r.Driver
+}
+
+func (r *CreateRequest) hostOptions(name string) (*host.Options, error) {
+	hostOptions := newHostOptions(name, &globalFlags{})
+
+	if err := decodeFields(r.Engine, hostOptions.EngineOptions, "engine."); err != nil {
+		return nil, err
+	}
+	if err := decodeFields(r.Swarm, hostOptions.SwarmOptions, "swarm."); err != nil {
+		return nil, err
+	}
+	for key, data := range r.Auth {
+		field, found := reflect.TypeOf(auth.Options{}).FieldByNameFunc(func(name string) bool {
+			return strings.EqualFold(name, key)
+		})
+		if found && !remoteAuthFields[field.Name] {
+			return nil, errInvalidParameter{"auth." + key, string(data), errLocalPath}
+		}
+	}
+	if err := decodeFields(r.Auth, hostOptions.AuthOptions, "auth."); err != nil {
+		return nil, err
+	}
+
+	return hostOptions, nil
+}
+
+func (r *CreateRequest) driverOptions(mcnflags []mcnflag.Flag) (drivers.DriverOptions, error) {
+	return parseJSONFlags(r.Options, mcnflags, commands.SharedCreateFlags)
+}
+
+// decodeFields decodes json values one by one into the fields of a struct,
+// so that a type mismatch can be reported with the name of the field.
+func decodeFields(fields map[string]json.RawMessage, target interface{}, prefix string) error {
+	value := reflect.ValueOf(target).Elem()
+
+	for key, data := range fields {
+		field, found := findField(value, key)
+		if !found {
+			return errInvalidParameter{prefix + key, string(data), errUnknownField}
+		}
+
+		if err := json.Unmarshal(data, field.Addr().Interface()); err != nil {
+			return errInvalidParameter{prefix + key, string(data), err}
+		}
+	}
+
+	return nil
+}
+
+// findField finds a field by its name or its json name, ignoring case.
+func findField(value reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if strings.EqualFold(field.Name, key) || (jsonName != "" && strings.EqualFold(jsonName, key)) {
+			return value.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// flagKinds gives the type of each create flag, indexed by name.
+func flagKinds(mcnflags []mcnflag.Flag, cliFlags []cli.Flag) map[string]string {
+	kinds := make(map[string]string)
+
+	for _, f := range cliFlags {
+		switch f := f.(type) {
+		case cli.StringFlag:
+			kinds[f.Name] = flagString
+		case cli.StringSliceFlag:
+			kinds[f.Name] = flagStringSlice
+		case cli.IntFlag:
+			kinds[f.Name] = flagInt
+		case cli.BoolFlag:
+			kinds[f.Name] = flagBool
+		}
+	}
+
+	for _, f := range mcnflags {
+		switch f.(type) {
+		case mcnflag.StringFlag:
+			kinds[f.String()] = flagString
+		case mcnflag.StringSliceFlag:
+			kinds[f.String()] = flagStringSlice
+		case mcnflag.IntFlag:
+			kinds[f.String()] = flagInt
+		case mcnflag.BoolFlag:
+			kinds[f.String()] = flagBool
+		}
+	}
+
+	return kinds
+}
+
+// parseJSONFlags is the json counterpart of parseFlags. Values must have
+// the exact type of the flag.
+func parseJSONFlags(options map[string]interface{}, mcnflags []mcnflag.Flag, cliFlags []cli.Flag) (drivers.DriverOptions, error) {
+	defaults, err := parseFlags(nil, mcnflags, cliFlags)
+	if err != nil {
+		return nil, err
+	}
+	driverOpts := defaults.(rpcdriver.RPCFlags)
+
+	kinds := flagKinds(mcnflags, cliFlags)
+	for key, value := range options {
+		kind, present := kinds[key]
+		if !present {
+			return nil, errInvalidParameter{"options." + key, fmt.Sprint(value), errUnknownField}
+		}
+
+		typed, ok := convertJSONValue(value, kind)
+		if !ok {
+			return nil, errInvalidParameter{"options." + key, fmt.Sprint(value), fmt.Errorf("Expected a value of type %s", kind)}
+		}
+
+		driverOpts.Values[key] = typed
+	}
+
+	return driverOpts, nil
+}
+
+func convertJSONValue(value interface{}, kind string) (interface{}, bool) {
+	switch kind {
+	case flagString:
+		s, ok := value.(string)
+		return s, ok
+	case flagBool:
+		b, ok := value.(bool)
+		return b, ok
+	case flagInt:
+		f, ok := value.(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, false
+		}
+		return int(f), true
+	case flagStringSlice:
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		slice := []string{}
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return nil, false
+			}
+			slice = append(slice, s)
+		}
+		return slice, true
+	}
+
+	return nil, false
+}
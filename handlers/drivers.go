@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+const (
+	pluginPrefix = "docker-machine-driver-"
+)
+
+// DriverItem is a driver as listed by the daemon.
+type DriverItem struct {
+	Name      string
+	Builtin   bool
+	Available bool
+}
+
+// DriverSchema describes the flags accepted to create a machine with a given driver.
+type DriverSchema struct {
+	Name        string
+	Flags       []FlagSchema
+	SharedFlags []FlagSchema
+}
+
+// FlagSchema describes a create flag.
+type FlagSchema struct {
+	Name    string
+	Type    string
+	Default interface{}
+	Usage   string
+	EnvVar  string
+}
+
+// ListDrivers lists the built-in drivers and the plugin drivers found in the PATH.
+func ListDrivers(args map[string]string, form map[string][]string) (interface{}, error) {
+	items := []DriverItem{}
+
+	builtins := make(map[string]bool)
+	for _, name := range localbinary.CoreDrivers {
+		builtins[name] = true
+
+		_, err := localbinary.NewPlugin(name)
+		items = append(items, DriverItem{name, true, err == nil})
+	}
+
+	for _, name := range findPlugins() {
+		if !builtins[name] {
+			items = append(items, DriverItem{name, false, true})
+		}
+	}
+
+	return items, nil
+}
+
+// findPlugins looks for docker-machine-driver-* binaries in the PATH.
+func findPlugins() []string {
+	found := make(map[string]bool)
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), ".exe")
+			if strings.HasPrefix(name, pluginPrefix) && !file.IsDir() {
+				found[strings.TrimPrefix(name, pluginPrefix)] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// InspectDriver describes the flags accepted by a driver.
+func InspectDriver(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	name, present := args["driver"]
+	if !present {
+		return nil, errRequireDriverName
+	}
+
+	mcnflags, err := driverCreateFlags(api, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return DriverSchema{
+		Name:        name,
+		Flags:       mcnFlagsSchema(mcnflags),
+		SharedFlags: cliFlagsSchema(commands.SharedCreateFlags),
+	}, nil
+}
+
+// driverCreateFlags starts a driver to ask for its create flags.
+func driverCreateFlags(api libmachine.API, driverName string) ([]mcnflag.Flag, error) {
+	rawDriver, err := json.Marshal(&drivers.BaseDriver{
+		StorePath: mcndirs.GetBaseDir(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := api.NewHost(driverName, rawDriver)
+	if err != nil {
+		if _, notFound := err.(localbinary.ErrPluginBinaryNotFound); notFound {
+			return nil, errDriverNotFound{driverName}
+		}
+		return nil, err
+	}
+
+	return h.Driver.GetCreateFlags(), nil
+}
+
+func mcnFlagsSchema(mcnflags []mcnflag.Flag) []FlagSchema {
+	schema := []FlagSchema{}
+
+	for _, f := range mcnflags {
+		switch f := f.(type) {
+		case mcnflag.StringFlag:
+			schema = append(schema, FlagSchema{f.Name, flagString, f.Value, f.Usage, f.EnvVar})
+		case mcnflag.StringSliceFlag:
+			schema = append(schema, FlagSchema{f.Name, flagStringSlice, f.Value, f.Usage, f.EnvVar})
+		case mcnflag.IntFlag:
+			schema = append(schema, FlagSchema{f.Name, flagInt, f.Value, f.Usage, f.EnvVar})
+		case mcnflag.BoolFlag:
+			schema = append(schema, FlagSchema{f.Name, flagBool, false, f.Usage, f.EnvVar})
+		}
+	}
+
+	return schema
+}
+
+func cliFlagsSchema(cliFlags []cli.Flag) []FlagSchema {
+	schema := []FlagSchema{}
+
+	for _, f := range cliFlags {
+		switch f := f.(type) {
+		case cli.StringFlag:
+			schema = append(schema, FlagSchema{flagName(f.Name), flagString, f.Value, f.Usage, f.EnvVar})
+		case cli.StringSliceFlag:
+			var value []string
+			if f.Value != nil {
+				value = f.Value.Value()
+			}
+			schema = append(schema, FlagSchema{flagName(f.Name), flagStringSlice, value, f.Usage, f.EnvVar})
+		case cli.IntFlag:
+			schema = append(schema, FlagSchema{flagName(f.Name), flagInt, f.Value, f.Usage, f.EnvVar})
+		case cli.BoolFlag:
+			schema = append(schema, FlagSchema{flagName(f.Name), flagBool, false, f.Usage, f.EnvVar})
+		}
+	}
+
+	return schema
+}
+
+// flagName removes the short aliases of a cli flag.
+func flagName(name string) string {
+	return strings.TrimSpace(strings.Split(name, ",")[0])
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine"
+)
+
+const (
+	// Same as docker-machine's env template, without the usage hint
+	envTmpl = `{{ .Prefix }}DOCKER_TLS_VERIFY{{ .Delimiter }}{{ .DockerTLSVerify }}{{ .Suffix }}{{ .Prefix }}DOCKER_HOST{{ .Delimiter }}{{ .DockerHost }}{{ .Suffix }}{{ .Prefix }}DOCKER_CERT_PATH{{ .Delimiter }}{{ .DockerCertPath }}{{ .Suffix }}{{ .Prefix }}DOCKER_MACHINE_NAME{{ .Delimiter }}{{ .MachineName }}{{ .Suffix }}`
+)
+
+// Env is the environment a Docker client needs to talk to a machine.
+type Env struct {
+	DockerHost        string `json:"DOCKER_HOST"`
+	DockerTLSVerify   string `json:"DOCKER_TLS_VERIFY"`
+	DockerCertPath    string `json:"DOCKER_CERT_PATH"`
+	DockerMachineName string `json:"DOCKER_MACHINE_NAME"`
+}
+
+// ShowEnv shows the environment needed to connect a Docker client to a Docker Machine
+func ShowEnv(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if formValue(form, "bundle") == "true" {
+		authOptions := h.AuthOptions()
+		if authOptions == nil {
+			return nil, errNoCertificates
+		}
+
+		bundle := fileBundle{
+			"ca.pem":   authOptions.CaCertPath,
+			"cert.pem": authOptions.ClientCertPath,
+			"key.pem":  authOptions.ClientKeyPath,
+		}
+		if err := bundle.checkFiles(); err != nil {
+			return nil, err
+		}
+
+		return bundle, nil
+	}
+
+	dockerHost, err := h.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	if formValue(form, "swarm") == "true" {
+		if h.HostOptions == nil || h.HostOptions.SwarmOptions == nil || !h.HostOptions.SwarmOptions.Master {
+			return nil, errNotSwarmMaster
+		}
+
+		dockerHost = toSwarmURL(dockerHost, h.HostOptions.SwarmOptions.Host)
+	}
+
+	env := Env{
+		DockerHost:        dockerHost,
+		DockerTLSVerify:   "1",
+		DockerCertPath:    filepath.Join(mcndirs.GetMachineDir(), h.Name),
+		DockerMachineName: h.Name,
+	}
+
+	userShell := formValue(form, "shell")
+	if userShell == "" {
+		return env, nil
+	}
+
+	shellCfg := &commands.ShellConfig{
+		DockerCertPath:  env.DockerCertPath,
+		DockerHost:      env.DockerHost,
+		DockerTLSVerify: env.DockerTLSVerify,
+		MachineName:     env.DockerMachineName,
+	}
+
+	switch userShell {
+	case "fish":
+		shellCfg.Prefix = "set -gx "
+		shellCfg.Suffix = "\";\n"
+		shellCfg.Delimiter = " \""
+	case "powershell":
+		shellCfg.Prefix = "$Env:"
+		shellCfg.Suffix = "\"\n"
+		shellCfg.Delimiter = " = \""
+	case "cmd":
+		shellCfg.Prefix = "SET "
+		shellCfg.Suffix = "\n"
+		shellCfg.Delimiter = "="
+	case "emacs":
+		shellCfg.Prefix = "(setenv \""
+		shellCfg.Suffix = "\")\n"
+		shellCfg.Delimiter = "\" \""
+	case "bash":
+		shellCfg.Prefix = "export "
+		shellCfg.Suffix = "\"\n"
+		shellCfg.Delimiter = "=\""
+	default:
+		return nil, errInvalidParameter{"shell", userShell, errUnknownShell}
+	}
+
+	var script bytes.Buffer
+	if err := template.Must(template.New("envConfig").Parse(envTmpl)).Execute(&script, shellCfg); err != nil {
+		return nil, err
+	}
+
+	return text(script.String()), nil
+}
+
+// text is a plain text response.
+type text string
+
+func (t text) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (t text) Stream(w io.Writer) error {
+	_, err := io.WriteString(w, string(t))
+	return err
+}
+
+// fileBundle is a tar archive of local files, indexed by their name in the archive.
+type fileBundle map[string]string
+
+func (b fileBundle) ContentType() string {
+	return "application/x-tar"
+}
+
+func (b fileBundle) Stream(w io.Writer) error {
+	archive := tar.NewWriter(w)
+
+	names := []string{}
+	for name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content, err := ioutil.ReadFile(b[name])
+		if err != nil {
+			return err
+		}
+
+		if err := archive.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0600,
+			Size: int64(len(content)),
+		}); err != nil {
+			return err
+		}
+
+		if _, err := archive.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// checkFiles makes sure a bundle can be built before anything is sent to the client.
+func (b fileBundle) checkFiles() error {
+	for _, path := range b {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("Error reading certificate: %s", err)
+		}
+	}
+
+	return nil
+}
@@ -1,8 +1,277 @@
 package handlers
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/machine/libmachine/mcnerror"
+	"github.com/docker/machine/libmachine/state"
+)
 
 var (
 	errRequireMachineName = errors.New("Requires one machine name")
 	errRequireDriverName  = errors.New("Requires a driver name")
+	errRequireJobID       = errors.New("Requires a job id")
+	errJobNotFound        = errors.New("Job not found")
+	errJobRunning         = errors.New("Job is still running")
+	errNotSwarmMaster     = errors.New("Machine is not a swarm master")
+	errNoCertificates     = errors.New("Machine has no certificates")
+	errUnknownShell       = errors.New("Supported shells are bash, fish, powershell, cmd and emacs")
+	errRequireCommand     = errors.New("Requires a command")
+	errCommandTimeout     = errors.New("Command timed out")
+	errRequirePath        = errors.New("Requires a path")
+	errUnknownSortKey     = errors.New("Supported sort keys are name, state and driver")
+	errUnknownField       = errors.New("Unknown field")
+)
+
+var (
+	errUnknownFailurePolicy    = errors.New("Supported failure policies are keep, remove and teardown")
+	errRequireProfileName      = errors.New("Requires a profile name")
+	errInvalidProfileName      = errors.New("Allowed profile name chars are: 0-9a-zA-Z . -")
+	errProfileWithJSON         = errors.New("Profiles can't be used with json documents")
+	errUnknownAction           = errors.New("Supported actions are start, stop, restart, kill and remove")
+	errNotPositive             = errors.New("Should be greater than zero")
+	errRequireMachineSelection = errors.New("Requires a list of machines or filters")
+	errRequireOptions          = errors.New("Requires engine or swarm options")
+	errShuttingDown            = errors.New("The daemon is shutting down")
+	errUnknownState            = errors.New("Supported states are Running, Paused, Saved, Stopped, Stopping, Starting, Error and Timeout")
+	errStreamInBackground      = errors.New("Streams can't run in the background")
+	errNotBool                 = errors.New("Should be true or false")
+	errNoHostOptions           = errors.New("Machine has no host options")
+	errUnsupportedContentType  = errors.New("Supported content types are application/json and application/x-www-form-urlencoded")
+	errLocalPath               = errors.New("Local paths can't be set remotely")
+	errJobForbidden            = errors.New("Requires the role of the action that started the job")
+	errMachineCAInUse          = errors.New("The daemon serves https with the CA of the machines, it can't be generated again")
 )
+
+// Error is the json body sent back when an action fails.
+type Error struct {
+	Code    string
+	Message string
+	Name    string      `json:",omitempty"`
+	Cause   string      `json:",omitempty"`
+	Details interface{} `json:",omitempty"`
+}
+
+// errInvalidParameter is returned when a form value can't be parsed.
+type errInvalidParameter struct {
+	Name  string
+	Value string
+	Cause error
+}
+
+func (e errInvalidParameter) Error() string {
+	return fmt.Sprintf("Invalid value %q for %s: %s", e.Value, e.Name, e.Cause)
+}
+
+// errMachineNotRunning is returned by actions that need a running machine.
+type errMachineNotRunning struct {
+	Name  string
+	State state.State
+}
+
+func (e errMachineNotRunning) Error() string {
+	return fmt.Sprintf("Machine %q is not running. Current state: %s", e.Name, e.State)
+}
+
+// errFileNotFound is returned when a file can't be found inside a machine.
+type errFileNotFound struct {
+	Name string
+	Path string
+}
+
+func (e errFileNotFound) Error() string {
+	return fmt.Sprintf("File %q not found on machine %q", e.Path, e.Name)
+}
+
+// errDriverNotFound is returned when neither a built-in driver nor a plugin has the given name.
+type errDriverNotFound struct {
+	Name string
+}
+
+func (e errDriverNotFound) Error() string {
+	return fmt.Sprintf("Driver %q not found", e.Name)
+}
+
+// errValidation lists all the problems found while validating a request.
+type errValidation []error
+
+func (e errValidation) Error() string {
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// errCreateFailed is returned when a machine failed to be created, after
+// the failure policy was applied.
+type errCreateFailed struct {
+	Cause   error
+	Failure CreateFailure
+}
+
+func (e errCreateFailed) Error() string {
+	return e.Cause.Error()
+}
+
+// errStateTimeout is returned when a machine doesn't reach a state in time.
+type errStateTimeout struct {
+	Name         string
+	DesiredState string
+	State        state.State
+}
+
+func (e errStateTimeout) Error() string {
+	return fmt.Sprintf("Machine %q is %s after waiting for it to be %s", e.Name, e.State, e.DesiredState)
+}
+
+// errProfileNotFound is returned when a profile doesn't exist.
+type errProfileNotFound struct {
+	Name string
+}
+
+func (e errProfileNotFound) Error() string {
+	return fmt.Sprintf("Profile %q not found", e.Name)
+}
+
+// errWithCause adds context to an error without losing track of it.
+type errWithCause struct {
+	Message string
+	Cause   error
+}
+
+func (e errWithCause) Error() string {
+	return fmt.Sprintf("%s: %s", e.Message, e.Cause)
+}
+
+// ToError converts an error into an http status code and a json body.
+func ToError(err error, name string) (int, Error) {
+	status, code := classify(err)
+
+	body := Error{
+		Code:    code,
+		Message: err.Error(),
+		Name:    name,
+		Cause:   cause(err),
+	}
+
+	if failed, ok := err.(errCreateFailed); ok {
+		body.Details = failed.Failure
+	}
+
+	if errs, ok := err.(errValidation); ok {
+		details := []Error{}
+		for _, err := range errs {
+			_, detail := ToError(err, name)
+			details = append(details, detail)
+		}
+		body.Details = details
+	}
+
+	return status, body
+}
+
+func classify(err error) (int, string) {
+	switch err := err.(type) {
+	case mcnerror.ErrHostDoesNotExist:
+		return http.StatusNotFound, "MachineNotFound"
+	case mcnerror.ErrHostAlreadyExists:
+		return http.StatusConflict, "MachineAlreadyExists"
+	case mcnerror.ErrHostAlreadyInState:
+		return http.StatusConflict, "MachineAlreadyInState"
+	case ErrMachineLocked:
+		return http.StatusConflict, "MachineLocked"
+	case errInvalidParameter:
+		return http.StatusBadRequest, "InvalidParameter"
+	case errMachineNotRunning:
+		return http.StatusConflict, "MachineNotRunning"
+	case errFileNotFound:
+		return http.StatusNotFound, "FileNotFound"
+	case errDriverNotFound:
+		return http.StatusNotFound, "DriverNotFound"
+	case errProfileNotFound:
+		return http.StatusNotFound, "ProfileNotFound"
+	case errStateTimeout:
+		return http.StatusGatewayTimeout, "StateTimeout"
+	case errValidation:
+		return http.StatusBadRequest, "ValidationFailed"
+	case errWithCause:
+		return classify(err.Cause)
+	case errCreateFailed:
+		return classify(err.Cause)
+	}
+
+	switch err {
+	case mcnerror.ErrInvalidHostname:
+		return http.StatusBadRequest, "InvalidMachineName"
+	case errRequireMachineName:
+		return http.StatusBadRequest, "MissingMachineName"
+	case errRequireDriverName:
+		return http.StatusBadRequest, "MissingDriverName"
+	case errRequireJobID:
+		return http.StatusBadRequest, "MissingJobID"
+	case errJobNotFound:
+		return http.StatusNotFound, "JobNotFound"
+	case errJobRunning:
+		return http.StatusConflict, "JobRunning"
+	case errJobForbidden:
+		return http.StatusForbidden, "Forbidden"
+	case errNotSwarmMaster:
+		return http.StatusBadRequest, "NotSwarmMaster"
+	case errNoCertificates:
+		return http.StatusConflict, "NoCertificates"
+	case errRequireProfileName:
+		return http.StatusBadRequest, "MissingProfileName"
+	case errRequireMachineSelection:
+		return http.StatusBadRequest, "MissingMachineSelection"
+	case errRequireOptions:
+		return http.StatusBadRequest, "MissingOptions"
+	case errShuttingDown:
+		return http.StatusServiceUnavailable, "ShuttingDown"
+	case errNoHostOptions:
+		return http.StatusConflict, "NoHostOptions"
+	case errMachineCAInUse:
+		return http.StatusConflict, "MachineCAInUse"
+	case errRequireCommand:
+		return http.StatusBadRequest, "MissingCommand"
+	case errRequirePath:
+		return http.StatusBadRequest, "MissingPath"
+	case errCommandTimeout:
+		return http.StatusGatewayTimeout, "CommandTimeout"
+	}
+
+	return http.StatusInternalServerError, "InternalError"
+}
+
+func cause(err error) string {
+	switch err := err.(type) {
+	case errWithCause:
+		return err.Cause.Error()
+	case errCreateFailed:
+		return cause(err.Cause)
+	case errInvalidParameter:
+		return err.Cause.Error()
+	case mcnerror.ErrDuringPreCreate:
+		return err.Cause.Error()
+	}
+
+	return ""
+}
+
+// checkAlreadyInState lets the request decide if acting on a machine that
+// already is in the desired state is an error or not.
+func checkAlreadyInState(err error, form map[string][]string) error {
+	if _, alreadyInState := err.(mcnerror.ErrHostAlreadyInState); !alreadyInState {
+		return err
+	}
+
+	if formValue(form, "alreadyInState") == "ok" {
+		return nil
+	}
+
+	return err
+}
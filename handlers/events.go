@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/persist"
+)
+
+const (
+	eventCreated      = "created"
+	eventRemoved      = "removed"
+	eventStateChanged = "state-changed"
+	eventError        = "error"
+
+	// Number of past events kept for the clients that resume a stream
+	eventHistorySize = 1000
+	// Number of events a slow client can lag behind before being disconnected
+	subscriberBufferSize = 100
+	heartbeatInterval    = 15 * time.Second
+)
+
+// Event is a change noticed on a machine.
+type Event struct {
+	ID            int64
+	Type          string
+	Name          string
+	Time          time.Time
+	State         string `json:",omitempty"`
+	URL           string `json:",omitempty"`
+	DockerVersion string `json:",omitempty"`
+	Error         string `json:",omitempty"`
+}
+
+// MachineItem is a machine as listed by the daemon.
+type MachineItem struct {
+	commands.HostListItem
+	LastUpdated time.Time
+}
+
+// watcher keeps an inventory of the machines, refreshed in the background,
+// and turns the differences between two refreshes into events. The drivers
+// are queried without holding the watcher, so refreshes can overlap: the
+// results of a refresh never replace those of a refresh that started later.
+type watcher struct {
+	sync.Mutex
+	machines    map[string]MachineItem
+	removed     map[string]time.Time
+	stale       map[string]bool
+	history     []Event
+	lastID      int64
+	subscribers map[chan Event]bool
+	closed      bool
+}
+
+var machineWatcher = &watcher{
+	removed:     make(map[string]time.Time),
+	stale:       make(map[string]bool),
+	subscribers: make(map[chan Event]bool),
+}
+
+// StartWatcher refreshes the inventory of the machines in the background,
+// until the daemon shuts down.
+func StartWatcher(interval time.Duration) {
+	go func() {
+		for {
+			api := libmachine.NewClient(mcndirs.GetBaseDir(), mcndirs.GetMachineCertDir())
+			end, err := operations.begin("Refresh", "")
+			if err != nil {
+				api.Close()
+				return
+			}
+			release := operations.use(api)
+
+			if err := machineWatcher.refreshAll(api, DefaultLockTimeout, true); err != nil {
+				log.Print(err)
+			}
+			release()
+			end()
+			api.Close()
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// WithRefresh makes sure the machine named in args is refreshed
+// before being listed again.
+func WithRefresh(handler func() (interface{}, error), args map[string]string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		if name, present := args["name"]; present {
+			defer machineWatcher.invalidate(name)
+		}
+
+		return handler()
+	}
+}
+
+// refreshAll refreshes every machine. Without withDockerVersion, the engines
+// are not queried and the Docker versions already known are kept.
+func (w *watcher) refreshAll(api libmachine.API, lockTimeout time.Duration, withDockerVersion bool) error {
+	started := time.Now()
+
+	unlock, err := locks.readStore(lockTimeout)
+	if err != nil {
+		return err
+	}
+
+	hostList, hostInError, err := persist.LoadAllHosts(api)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	w.update(listHosts(hostList, hostInError, withDockerVersion), nil, started, withDockerVersion)
+
+	return nil
+}
+
+// refreshStale only refreshes the machines that were invalidated.
+func (w *watcher) refreshStale(api libmachine.API, withDockerVersion bool) error {
+	started := time.Now()
+
+	names := w.takeStale()
+	if len(names) == 0 {
+		return nil
+	}
+
+	existing := []string{}
+	for _, name := range names {
+		exists, err := api.Exists(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			existing = append(existing, name)
+		}
+	}
+
+	unlock, err := locks.readStore(DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+
+	hostList, hostInError := persist.LoadHosts(api, existing)
+	unlock()
+
+	w.update(listHosts(hostList, hostInError, withDockerVersion), names, started, withDockerVersion)
+
+	return nil
+}
+
+func (w *watcher) ready() bool {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.machines != nil
+}
+
+func (w *watcher) invalidate(name string) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.stale[name] = true
+}
+
+func (w *watcher) takeStale() []string {
+	w.Lock()
+	defer w.Unlock()
+
+	names := []string{}
+	for name := range w.stale {
+		names = append(names, name)
+	}
+	w.stale = make(map[string]bool)
+
+	return names
+}
+
+func (w *watcher) list() []MachineItem {
+	w.Lock()
+	defer w.Unlock()
+
+	items := []MachineItem{}
+	for _, item := range w.machines {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// update records the new items of a refresh that started at the given time.
+// Machines of the scope that are not part of the items are considered removed.
+// A nil scope covers every machine. Without withDockerVersion, the items keep
+// the Docker version already known.
+func (w *watcher) update(items []commands.HostListItem, scope []string, started time.Time, withDockerVersion bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	// The first refresh only takes a snapshot
+	first := w.machines == nil
+	if first {
+		w.machines = make(map[string]MachineItem)
+	}
+
+	updated := make(map[string]bool)
+
+	for _, item := range items {
+		updated[item.Name] = true
+		previous, present := w.machines[item.Name]
+		if present && previous.LastUpdated.After(started) {
+			continue
+		}
+		if !present && w.removed[item.Name].After(started) {
+			continue
+		}
+		if present && !withDockerVersion {
+			item.DockerVersion = previous.DockerVersion
+		}
+
+		switch {
+		case first:
+		case !present:
+			w.publish(eventCreated, item)
+		case item.Error != "" && item.Error != previous.Error:
+			w.publish(eventError, item)
+		case item.State != previous.State || item.URL != previous.URL || item.DockerVersion != previous.DockerVersion:
+			w.publish(eventStateChanged, item)
+		}
+
+		w.machines[item.Name] = MachineItem{item, started}
+		delete(w.removed, item.Name)
+	}
+
+	if scope == nil {
+		for name := range w.machines {
+			scope = append(scope, name)
+		}
+	}
+
+	for _, name := range scope {
+		item, present := w.machines[name]
+		if present && !updated[name] && !item.LastUpdated.After(started) {
+			w.publish(eventRemoved, item.HostListItem)
+			delete(w.machines, name)
+			w.removed[name] = started
+		}
+	}
+}
+
+// publish must be called with the watcher locked.
+func (w *watcher) publish(eventType string, item commands.HostListItem) {
+	w.lastID++
+
+	event := Event{
+		ID:            w.lastID,
+		Type:          eventType,
+		Name:          item.Name,
+		Time:          time.Now(),
+		State:         item.State.String(),
+		URL:           item.URL,
+		DockerVersion: item.DockerVersion,
+		Error:         item.Error,
+	}
+
+	w.history = append(w.history, event)
+	if len(w.history) > eventHistorySize {
+		w.history = w.history[len(w.history)-eventHistorySize:]
+	}
+
+	for subscriber := range w.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// Too slow, the client will have to resume from its last event
+			delete(w.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// subscribe returns the past events that came after lastID and a channel for the next ones.
+func (w *watcher) subscribe(lastID int64) ([]Event, chan Event) {
+	w.Lock()
+	defer w.Unlock()
+
+	past := []Event{}
+	for _, event := range w.history {
+		if event.ID > lastID {
+			past = append(past, event)
+		}
+	}
+
+	subscriber := make(chan Event, subscriberBufferSize)
+	if w.closed {
+		close(subscriber)
+		return past, subscriber
+	}
+	w.subscribers[subscriber] = true
+
+	return past, subscriber
+}
+
+func (w *watcher) unsubscribe(subscriber chan Event) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.subscribers[subscriber] {
+		delete(w.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// closeSubscribers ends the event streams, for good.
+func (w *watcher) closeSubscribers() {
+	w.Lock()
+	defer w.Unlock()
+
+	w.closed = true
+	for subscriber := range w.subscribers {
+		delete(w.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// Events streams the changes on the machines as server-sent events.
+func Events(args map[string]string, form map[string][]string) (interface{}, error) {
+	var lastID int64
+	if value := formValue(form, "lastEventId"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errInvalidParameter{"lastEventId", value, err}
+		}
+		lastID = id
+	}
+
+	return &eventStream{
+		lastID: lastID,
+		names:  toSet(form["name"]),
+		types:  toSet(form["type"]),
+	}, nil
+}
+
+type eventStream struct {
+	lastID int64
+	names  map[string]bool
+	types  map[string]bool
+}
+
+func (s *eventStream) ContentType() string {
+	return "text/event-stream"
+}
+
+func (s *eventStream) Stream(w io.Writer) error {
+	past, subscriber := machineWatcher.subscribe(s.lastID)
+	defer machineWatcher.unsubscribe(subscriber)
+
+	// Sends the headers right away
+	if _, err := io.WriteString(w, ": connected\n\n"); err != nil {
+		return err
+	}
+
+	for _, event := range past {
+		if err := s.send(w, event); err != nil {
+			return err
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, open := <-subscriber:
+			if !open {
+				return nil
+			}
+			if err := s.send(w, event); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			// Detects the clients that are gone
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *eventStream) send(w io.Writer, event Event) error {
+	if len(s.names) > 0 && !s.names[event.Name] {
+		return nil
+	}
+	if len(s.types) > 0 && !s.types[event.Type] {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+)
+
+// Upload copies the body of the request to a file inside a Docker Machine.
+// With archive=true, the body is a tar extracted into a directory.
+func Upload(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
+	path := formValue(form, "path")
+	if path == "" {
+		return nil, errRequirePath
+	}
+
+	h, err := loadRunningMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := formValue(form, "archive") == "true"
+
+	recursive := ""
+	command := fmt.Sprintf("cat > %s", quote(path))
+	if archive {
+		recursive = "-R "
+		command = fmt.Sprintf("mkdir -p %s && tar -xf - -C %s", quote(path), quote(path))
+	}
+
+	if mode := formValue(form, "mode"); mode != "" {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			return nil, errInvalidParameter{"mode", mode, err}
+		}
+		if archive {
+			// Directories keep their mode so that they can still be traversed
+			command += fmt.Sprintf(" && find %s -type f -exec chmod %s {} +", quote(path), mode)
+		} else {
+			command += fmt.Sprintf(" && chmod %s %s", mode, quote(path))
+		}
+	}
+
+	if owner := formValue(form, "owner"); owner != "" {
+		command += fmt.Sprintf(" && chown %s%s %s", recursive, quote(owner), quote(path))
+	}
+
+	if formValue(form, "sudo") == "true" {
+		command = fmt.Sprintf("sudo sh -c %s", quote(command))
+	}
+
+	if err := runCommand(h, command, body, nil); err != nil {
+		return nil, errWithCause{"Error uploading " + path, err}
+	}
+
+	return Success{"uploaded", h.Name}, nil
+}
+
+// Download sends a file from inside a Docker Machine. Directories are sent as tar archives.
+func Download(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	path := formValue(form, "path")
+	if path == "" {
+		return nil, errRequirePath
+	}
+
+	h, err := loadRunningMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	sudo := ""
+	if formValue(form, "sudo") == "true" {
+		sudo = "sudo "
+	}
+
+	var fileType bytes.Buffer
+	if err := runCommand(h, fmt.Sprintf("if %stest -d %s; then echo directory; elif %stest -e %s; then echo file; fi", sudo, quote(path), sudo, quote(path)), nil, &fileType); err != nil {
+		return nil, err
+	}
+
+	command := fmt.Sprintf("%scat %s", sudo, quote(path))
+	contentType := "application/octet-stream"
+
+	switch strings.TrimSpace(fileType.String()) {
+	case "directory":
+		command = fmt.Sprintf("%star -cf - -C %s .", sudo, quote(path))
+		contentType = "application/x-tar"
+	case "":
+		return nil, errFileNotFound{h.Name, path}
+	}
+
+	// The session is opened while the driver is still reachable.
+	session, err := newSSHSession(h, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteFile{session, command, contentType}, nil
+}
+
+// remoteFile streams the output of a command run inside a machine.
+type remoteFile struct {
+	session     sshSession
+	command     string
+	contentType string
+}
+
+func (f *remoteFile) ContentType() string {
+	return f.contentType
+}
+
+func (f *remoteFile) Stream(w io.Writer) error {
+	return execSSH(f.session, f.command, nil, w)
+}
+
+func runCommand(h *host.Host, command string, stdin io.Reader, stdout io.Writer) error {
+	session, err := newSSHSession(h, 0)
+	if err != nil {
+		return err
+	}
+
+	return execSSH(session, command, stdin, stdout)
+}
+
+// execSSH runs a command, closes the session and turns a non zero exit
+// status into an error made of the status and what the command wrote to stderr.
+func execSSH(s sshSession, command string, stdin io.Reader, stdout io.Writer) error {
+	defer s.Close()
+
+	var stderr bytes.Buffer
+	status, err := runSSH(s, command, stdin, stdout, &stderr, 0)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		message := fmt.Sprintf("Exit status %d", status)
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			message += ": " + output
+		}
+		return errors.New(message)
+	}
+
+	return nil
+}
+
+// quote quotes a string for the remote shell.
+func quote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
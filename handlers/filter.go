@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/skarademir/naturalsort"
+)
+
+// machineFilters selects machines the same way docker-machine ls --filter does.
+type machineFilters struct {
+	drivers []string
+	states  []string
+	names   []*regexp.Regexp
+	labels  []string
+	swarms  []string
+}
+
+func parseFilters(form map[string][]string) (machineFilters, error) {
+	filters := machineFilters{
+		drivers: form["driver"],
+		states:  form["state"],
+		labels:  form["label"],
+		swarms:  form["swarm"],
+	}
+
+	for _, name := range form["name"] {
+		r, err := regexp.Compile(name)
+		if err != nil {
+			return filters, errInvalidParameter{"name", name, err}
+		}
+
+		filters.names = append(filters.names, r)
+	}
+
+	return filters, nil
+}
+
+func (f machineFilters) isEmpty() bool {
+	return len(f.drivers) == 0 && len(f.states) == 0 && len(f.names) == 0 && len(f.labels) == 0 && len(f.swarms) == 0
+}
+
+func (f machineFilters) apply(items []MachineItem) []MachineItem {
+	swarmMasters := make(map[string]string)
+	for _, item := range items {
+		if item.SwarmOptions != nil && item.SwarmOptions.Master {
+			swarmMasters[item.SwarmOptions.Discovery] = item.Name
+		}
+	}
+
+	filtered := []MachineItem{}
+	for _, item := range items {
+		if f.matches(item, swarmMasters) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return filtered
+}
+
+func (f machineFilters) matches(item MachineItem, swarmMasters map[string]string) bool {
+	if len(f.drivers) > 0 && !containsFold(f.drivers, item.DriverName) {
+		return false
+	}
+
+	if len(f.states) > 0 && !containsFold(f.states, item.State.String()) {
+		return false
+	}
+
+	if len(f.names) > 0 && !matchesAny(f.names, item.Name) {
+		return false
+	}
+
+	if len(f.swarms) > 0 {
+		if item.SwarmOptions == nil || !containsFold(f.swarms, swarmMasters[item.SwarmOptions.Discovery]) {
+			return false
+		}
+	}
+
+	if len(f.labels) > 0 {
+		if item.EngineOptions == nil || !matchesLabel(f.labels, item.EngineOptions.Labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesLabel(filters []string, labels []string) bool {
+	values := make(map[string]string)
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) == 2 {
+			values[kv[0]] = kv[1]
+		}
+	}
+
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if value, present := values[kv[0]]; present && len(kv) == 2 && strings.EqualFold(value, kv[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortItems sorts the machines by the given key, then by name, using a natural sort.
+func sortItems(items []MachineItem, key string) error {
+	var sortKey func(item MachineItem) string
+
+	switch key {
+	case "", "name":
+		sortKey = func(item MachineItem) string { return "" }
+	case "state":
+		sortKey = func(item MachineItem) string { return item.State.String() }
+	case "driver":
+		sortKey = func(item MachineItem) string { return item.DriverName }
+	default:
+		return errInvalidParameter{"sort", key, errUnknownSortKey}
+	}
+
+	sort.Sort(&byKey{items, sortKey})
+
+	return nil
+}
+
+type byKey struct {
+	items []MachineItem
+	key   func(item MachineItem) string
+}
+
+func (s *byKey) Len() int {
+	return len(s.items)
+}
+
+func (s *byKey) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+}
+
+func (s *byKey) Less(i, j int) bool {
+	keyI, keyJ := s.key(s.items[i]), s.key(s.items[j])
+	if keyI != keyJ {
+		return naturalLess(keyI, keyJ)
+	}
+
+	return naturalLess(s.items[i].Name, s.items[j].Name)
+}
+
+func naturalLess(a, b string) bool {
+	return naturalsort.NaturalSort([]string{a, b}).Less(0, 1)
+}
+
+// parseFields reads the columns to return. A nil result means every column.
+func parseFields(form map[string][]string) ([]string, error) {
+	values, present := form["fields"]
+	if !present {
+		return nil, nil
+	}
+
+	known, err := toMap(MachineItem{})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			canonical := ""
+			for name := range known {
+				if strings.EqualFold(name, strings.TrimSpace(field)) {
+					canonical = name
+				}
+			}
+
+			if canonical == "" {
+				return nil, errInvalidParameter{"fields", field, errUnknownField}
+			}
+
+			fields = append(fields, canonical)
+		}
+	}
+
+	return fields, nil
+}
+
+func selectFields(items []MachineItem, fields []string) ([]map[string]interface{}, error) {
+	selected := []map[string]interface{}{}
+
+	for _, item := range items {
+		all, err := toMap(item)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := make(map[string]interface{})
+		for _, field := range fields {
+			columns[field] = all[field]
+		}
+
+		selected = append(selected, columns)
+	}
+
+	return selected, nil
+}
+
+func toMap(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
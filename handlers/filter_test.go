@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/docker/machine/libmachine/swarm"
+)
+
+func item(name string, driver string, s state.State) MachineItem {
+	return MachineItem{HostListItem: commands.HostListItem{Name: name, DriverName: driver, State: s}}
+}
+
+func names(items []MachineItem) []string {
+	list := []string{}
+	for _, item := range items {
+		list = append(list, item.Name)
+	}
+
+	return list
+}
+
+func TestParseFiltersInvalidName(t *testing.T) {
+	_, err := parseFilters(map[string][]string{"name": {"dev["}})
+	if _, invalid := err.(errInvalidParameter); !invalid {
+		t.Errorf("Expected errInvalidParameter, got %v", err)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	master := item("master", "virtualbox", state.Running)
+	master.SwarmOptions = &swarm.Options{Master: true, Discovery: "token://a"}
+	agent := item("agent", "virtualbox", state.Stopped)
+	agent.SwarmOptions = &swarm.Options{Discovery: "token://a"}
+	labelled := item("dev-labelled", "digitalocean", state.Running)
+	labelled.EngineOptions = &engine.Options{Labels: []string{"env=Prod", "team=core"}}
+	dev := item("dev", "generic", state.Error)
+
+	items := []MachineItem{master, agent, labelled, dev}
+
+	tests := []struct {
+		form     map[string][]string
+		expected []string
+	}{
+		{map[string][]string{}, []string{"master", "agent", "dev-labelled", "dev"}},
+		{map[string][]string{"driver": {"VirtualBox"}}, []string{"master", "agent"}},
+		{map[string][]string{"driver": {"generic", "digitalocean"}}, []string{"dev-labelled", "dev"}},
+		{map[string][]string{"state": {"stopped"}}, []string{"agent"}},
+		{map[string][]string{"state": {"Running"}, "driver": {"virtualbox"}}, []string{"master"}},
+		{map[string][]string{"name": {"^dev"}}, []string{"dev-labelled", "dev"}},
+		{map[string][]string{"name": {"gent"}}, []string{"agent"}},
+		{map[string][]string{"name": {"^dev$", "^master$"}}, []string{"master", "dev"}},
+		{map[string][]string{"swarm": {"MASTER"}}, []string{"master", "agent"}},
+		{map[string][]string{"swarm": {"agent"}}, []string{}},
+		{map[string][]string{"label": {"env=prod"}}, []string{"dev-labelled"}},
+		{map[string][]string{"label": {"ENV=prod"}}, []string{}},
+		{map[string][]string{"label": {"env"}}, []string{}},
+		{map[string][]string{"label": {"env=dev", "team=core"}}, []string{"dev-labelled"}},
+	}
+
+	for _, test := range tests {
+		filters, err := parseFilters(test.form)
+		if err != nil {
+			t.Fatalf("%v: %s", test.form, err)
+		}
+
+		if filtered := names(filters.apply(items)); !reflect.DeepEqual(filtered, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.form, test.expected, filtered)
+		}
+	}
+}
+
+func TestMatchesLabel(t *testing.T) {
+	tests := []struct {
+		filters  []string
+		labels   []string
+		expected bool
+	}{
+		{[]string{"env=prod"}, []string{"env=prod"}, true},
+		{[]string{"env=prod"}, []string{"env=PROD"}, true},
+		{[]string{"Env=prod"}, []string{"env=prod"}, false},
+		{[]string{"env=prod"}, []string{"env=dev"}, false},
+		{[]string{"env=a=b"}, []string{"env=a=b"}, true},
+		{[]string{"env="}, []string{"env="}, true},
+		{[]string{"env"}, []string{"env=prod"}, false},
+		{[]string{"env=prod"}, []string{"env"}, false},
+		{[]string{"env=prod"}, nil, false},
+	}
+
+	for _, test := range tests {
+		if matches := matchesLabel(test.filters, test.labels); matches != test.expected {
+			t.Errorf("filters %v, labels %v: expected %v, got %v", test.filters, test.labels, test.expected, matches)
+		}
+	}
+}
+
+func TestSortItems(t *testing.T) {
+	tests := []struct {
+		key      string
+		items    []MachineItem
+		expected []string
+	}{
+		{
+			"",
+			[]MachineItem{item("node10", "", state.None), item("node2", "", state.None), item("node1", "", state.None)},
+			[]string{"node1", "node2", "node10"},
+		},
+		{
+			"name",
+			[]MachineItem{item("b", "", state.None), item("a10", "", state.None), item("a9", "", state.None)},
+			[]string{"a9", "a10", "b"},
+		},
+		{
+			"driver",
+			[]MachineItem{item("dev2", "virtualbox", state.None), item("dev10", "generic", state.None), item("dev1", "virtualbox", state.None)},
+			[]string{"dev10", "dev1", "dev2"},
+		},
+		{
+			"state",
+			[]MachineItem{item("dev10", "", state.Stopped), item("dev2", "", state.Running), item("dev1", "", state.Stopped)},
+			[]string{"dev2", "dev1", "dev10"},
+		},
+	}
+
+	for _, test := range tests {
+		if err := sortItems(test.items, test.key); err != nil {
+			t.Fatalf("%q: %s", test.key, err)
+		}
+
+		if sorted := names(test.items); !reflect.DeepEqual(sorted, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.key, test.expected, sorted)
+		}
+	}
+}
+
+func TestSortItemsUnknownKey(t *testing.T) {
+	err := sortItems([]MachineItem{}, "url")
+	if _, invalid := err.(errInvalidParameter); !invalid {
+		t.Errorf("Expected errInvalidParameter, got %v", err)
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		form     map[string][]string
+		expected []string
+		invalid  bool
+	}{
+		{map[string][]string{}, nil, false},
+		{map[string][]string{"fields": {"Name"}}, []string{"Name"}, false},
+		{map[string][]string{"fields": {"name,drivername"}}, []string{"Name", "DriverName"}, false},
+		{map[string][]string{"fields": {"name, state", "URL"}}, []string{"Name", "State", "URL"}, false},
+		{map[string][]string{"fields": {"name,bogus"}}, nil, true},
+		{map[string][]string{"fields": {""}}, nil, true},
+	}
+
+	for _, test := range tests {
+		fields, err := parseFields(test.form)
+		if test.invalid {
+			if _, invalid := err.(errInvalidParameter); !invalid {
+				t.Errorf("%v: expected errInvalidParameter, got %v", test.form, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %s", test.form, err)
+		}
+
+		if !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.form, test.expected, fields)
+		}
+	}
+}
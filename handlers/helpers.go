@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/docker/machine/libmachine"
 	"github.com/docker/machine/libmachine/host"
 )
@@ -13,3 +15,40 @@ func loadOneMachine(api libmachine.API, args map[string]string) (*host.Host, err
 
 	return api.Load(name)
 }
+
+// durationValue reads a duration from the form. It defaults to zero.
+func durationValue(form map[string][]string, key string) (time.Duration, error) {
+	value := formValue(form, key)
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errInvalidParameter{key, value, err}
+	}
+
+	return duration, nil
+}
+
+func formValue(form map[string][]string, key string) string {
+	values, present := form[key]
+	if present && len(values) == 1 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// parseBool reads a boolean flag. Only true and false are accepted, so that
+// what is validated is what is used.
+func parseBool(value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, errNotBool
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/docker/machine/libmachine"
+)
+
+const (
+	redacted = "<redacted>"
+)
+
+// Configuration keys that hold secrets, either credentials of the drivers or
+// paths to private keys.
+var secretKeys = map[string]bool{
+	"AccessKey":        true,
+	"AccessToken":      true,
+	"ApiKey":           true,
+	"ApiSecretKey":     true,
+	"CaPrivateKeyPath": true,
+	"ClientKeyPath":    true,
+	"ClientSecret":     true,
+	"Password":         true,
+	"SecretKey":        true,
+	"ServerKeyPath":    true,
+	"SessionToken":     true,
+	"SSHKey":           true,
+	"SSHKeyPath":       true,
+	"SSHPassword":      true,
+	"UserPassword":     true,
+}
+
+// Inspect shows the full configuration of a Docker Machine. Secrets are
+// redacted unless an admin passes redact=false.
+func Inspect(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The driver is marshalled through the plugin so this has to
+	// be done before the api is closed.
+	rawHost, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(rawHost, &config); err != nil {
+		return nil, err
+	}
+
+	if formValue(form, "redact") != "false" || !HasRole(args[CallerRole], RoleAdmin) {
+		redactSecrets(config)
+	}
+
+	return config, nil
+}
+
+func redactSecrets(config map[string]interface{}) {
+	for key, value := range config {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			redactSecrets(value)
+		case string:
+			if value != "" && secretKeys[key] {
+				config[key] = redacted
+			}
+		case []interface{}:
+			if len(value) > 0 && secretKeys[key] {
+				config[key] = []string{redacted}
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+
+	// How long finished jobs are kept
+	jobTTL = 1 * time.Hour
+)
+
+// Job is a handler running in the background. Only the callers with the
+// role required by the action can see its result and remove it.
+type Job struct {
+	ID     string
+	Action string
+	Name   string
+	Role   string
+	Status string
+	Start  time.Time
+	End    *time.Time  `json:",omitempty"`
+	Result interface{} `json:",omitempty"`
+	Error  *Error      `json:",omitempty"`
+}
+
+type jobList struct {
+	sync.Mutex
+	jobs map[string]*Job
+}
+
+var jobs = &jobList{
+	jobs: make(map[string]*Job),
+}
+
+// StartJob runs a handler in the background and returns the job that tracks it.
+// No job can start once the daemon is shutting down.
+func StartJob(action string, name string, role string, handler func() (interface{}, error)) (Job, error) {
+	end, err := operations.begin(action, name)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:     newJobID(),
+		Action: action,
+		Name:   name,
+		Role:   role,
+		Status: jobRunning,
+		Start:  time.Now(),
+	}
+
+	jobs.Lock()
+	jobs.prune()
+	jobs.jobs[job.ID] = job
+	snapshot := *job
+	jobs.Unlock()
+
+	go func() {
+		defer end()
+
+		result, err := handler()
+
+		jobs.Lock()
+		defer jobs.Unlock()
+
+		end := time.Now()
+		job.End = &end
+		if err != nil {
+			_, jobError := ToError(err, job.Name)
+			job.Status = jobFailed
+			job.Error = &jobError
+		} else {
+			job.Status = jobSucceeded
+			job.Result = result
+		}
+	}()
+
+	return snapshot, nil
+}
+
+// ListJobs lists the jobs, running or finished.
+func ListJobs(args map[string]string, form map[string][]string) (interface{}, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	jobs.prune()
+
+	list := []Job{}
+	for _, job := range jobs.jobs {
+		list = append(list, job.visibleTo(args[CallerRole]))
+	}
+	sort.Sort(byStart(list))
+
+	return list, nil
+}
+
+// InspectJob shows the status of a single job.
+func InspectJob(args map[string]string, form map[string][]string) (interface{}, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	job, err := findJob(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.visibleTo(args[CallerRole]), nil
+}
+
+// RemoveJob forgets about a finished job.
+func RemoveJob(args map[string]string, form map[string][]string) (interface{}, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	job, err := findJob(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if !HasRole(args[CallerRole], job.Role) {
+		return nil, errJobForbidden
+	}
+
+	if job.Status == jobRunning {
+		return nil, errJobRunning
+	}
+
+	delete(jobs.jobs, job.ID)
+
+	return Success{"removed", job.ID}, nil
+}
+
+// visibleTo copies a job, without its result and its error unless
+// the caller has the role required by the action.
+func (j *Job) visibleTo(role string) Job {
+	job := *j
+	if !HasRole(role, job.Role) {
+		job.Result = nil
+		job.Error = nil
+	}
+
+	return job
+}
+
+// prune forgets the jobs that finished more than jobTTL ago.
+// It must be called with the job list locked.
+func (l *jobList) prune() {
+	for id, job := range l.jobs {
+		if job.End != nil && time.Since(*job.End) > jobTTL {
+			delete(l.jobs, id)
+		}
+	}
+}
+
+// findJob must be called with the job list locked.
+func findJob(args map[string]string) (*Job, error) {
+	id, present := args["id"]
+	if !present {
+		return nil, errRequireJobID
+	}
+
+	job, present := jobs.jobs[id]
+	if !present {
+		return nil, errJobNotFound
+	}
+
+	return job, nil
+}
+
+func newJobID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+type byStart []Job
+
+func (jobs byStart) Len() int {
+	return len(jobs)
+}
+
+func (jobs byStart) Swap(i, j int) {
+	jobs[i], jobs[j] = jobs[j], jobs[i]
+}
+
+func (jobs byStart) Less(i, j int) bool {
+	return jobs[i].Start.Before(jobs[j].Start)
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import "github.com/docker/machine/libmachine"
+
+// Kill forcefully stops a Docker Machine
+func Kill(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Kill(); err != nil {
+		if err := checkAlreadyInState(err, form); err != nil {
+			return nil, err
+		}
+	}
+
+	return Success{"killed", h.Name}, nil
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+)
+
+// DefaultLockTimeout is how long a request waits for a busy machine,
+// unless it says otherwise.
+var DefaultLockTimeout = 1 * time.Minute
+
+// ErrMachineLocked is returned when a machine is busy with another action
+// for longer than the request agreed to wait.
+type ErrMachineLocked struct {
+	Name string
+}
+
+func (e ErrMachineLocked) Error() string {
+	if e.Name == "" {
+		return "The machine store is busy with another action"
+	}
+
+	return fmt.Sprintf("Machine %q is busy with another action", e.Name)
+}
+
+// libmachine is not thread safe, specially when it saves machines to the disk.
+// Actions on the same machine are serialized while actions on different machines
+// run concurrently. The store lock is shared by the actions that read the machines
+// directory and taken exclusively to write to it.
+type lockManager struct {
+	sync.Mutex
+	store    *sharedLock
+	machines map[string]*machineLock
+}
+
+// machineLock is removed from the lock manager once nobody uses it.
+type machineLock struct {
+	lock chan struct{}
+	refs int
+}
+
+var locks = &lockManager{
+	store:    newSharedLock(),
+	machines: make(map[string]*machineLock),
+}
+
+// lockMachine waits for at most timeout to acquire the lock of a machine.
+// It returns the function to call to release the lock.
+func (l *lockManager) lockMachine(name string, timeout time.Duration) (func(), error) {
+	l.Lock()
+	machine, present := l.machines[name]
+	if !present {
+		machine = &machineLock{lock: make(chan struct{}, 1)}
+		l.machines[name] = machine
+	}
+	machine.refs++
+	l.Unlock()
+
+	forget := func() {
+		l.Lock()
+		defer l.Unlock()
+
+		machine.refs--
+		if machine.refs == 0 {
+			delete(l.machines, name)
+		}
+	}
+
+	release, err := acquire(machine.lock, name, timeout)
+	if err != nil {
+		forget()
+		return nil, err
+	}
+
+	return func() {
+		release()
+		forget()
+	}, nil
+}
+
+// readStore waits for at most timeout to share the store wide lock.
+// It returns the function to call to release the lock.
+func (l *lockManager) readStore(timeout time.Duration) (func(), error) {
+	return l.store.acquire(false, timeout)
+}
+
+// writeStore waits for at most timeout to acquire the store wide lock exclusively.
+// It returns the function to call to release the lock.
+func (l *lockManager) writeStore(timeout time.Duration) (func(), error) {
+	return l.store.acquire(true, timeout)
+}
+
+// sharedLock is a readers-writer lock that can be waited for with a timeout.
+type sharedLock struct {
+	sync.Mutex
+	readers int
+	writer  bool
+	// changed is closed, then replaced, each time the lock is released
+	changed chan struct{}
+}
+
+func newSharedLock() *sharedLock {
+	return &sharedLock{changed: make(chan struct{})}
+}
+
+func (l *sharedLock) acquire(exclusive bool, timeout time.Duration) (func(), error) {
+	deadline := time.After(timeout)
+
+	for {
+		l.Lock()
+		if !l.writer && (!exclusive || l.readers == 0) {
+			if exclusive {
+				l.writer = true
+			} else {
+				l.readers++
+			}
+			l.Unlock()
+
+			return func() { l.release(exclusive) }, nil
+		}
+		changed := l.changed
+		l.Unlock()
+
+		if timeout <= 0 {
+			return nil, ErrMachineLocked{}
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, ErrMachineLocked{}
+		}
+	}
+}
+
+func (l *sharedLock) release(exclusive bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if exclusive {
+		l.writer = false
+	} else {
+		l.readers--
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func acquire(lock chan struct{}, name string, timeout time.Duration) (func(), error) {
+	release := func() { <-lock }
+
+	select {
+	case lock <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if timeout <= 0 {
+		return nil, ErrMachineLocked{name}
+	}
+
+	select {
+	case lock <- struct{}{}:
+		return release, nil
+	case <-time.After(timeout):
+		return nil, ErrMachineLocked{name}
+	}
+}
+
+// lockTimeout reads how long a request agrees to wait for a busy machine.
+// Zero means fail fast.
+func lockTimeout(form map[string][]string) (time.Duration, error) {
+	values, present := form["lockTimeout"]
+	if !present || len(values) != 1 {
+		return DefaultLockTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(values[0])
+	if err != nil {
+		return 0, errInvalidParameter{"lockTimeout", values[0], err}
+	}
+
+	return timeout, nil
+}
+
+// WithLock serializes the actions on the machine named in args.
+func WithLock(handler func() (interface{}, error), args map[string]string, form map[string][]string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		name, present := args["name"]
+		if !present {
+			return handler()
+		}
+
+		timeout, err := lockTimeout(form)
+		if err != nil {
+			return nil, err
+		}
+
+		unlock, err := locks.lockMachine(name, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		locked := true
+		defer func() {
+			if locked {
+				unlock()
+			}
+		}()
+
+		result, err := handler()
+		if streamer, ok := result.(Streamer); ok && err == nil {
+			// The machine stays locked until the stream is over
+			locked = false
+			return &lockedStreamer{streamer, unlock}, nil
+		}
+
+		return result, err
+	}
+}
+
+// lockedStreamer releases a lock once it's done streaming.
+type lockedStreamer struct {
+	Streamer
+	unlock func()
+}
+
+func (s *lockedStreamer) Stream(w io.Writer) error {
+	defer s.unlock()
+
+	return s.Streamer.Stream(w)
+}
+
+// lockedStore loads the machines with the store lock shared and writes
+// them with the store lock held exclusively. The saves that libmachine's
+// Create makes on its own don't go through it: a machine being created
+// can fail to load while its config.json is written or replaced.
+type lockedStore struct {
+	libmachine.API
+}
+
+func (s lockedStore) Load(name string) (*host.Host, error) {
+	unlock, err := locks.readStore(DefaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.API.Load(name)
+}
+
+func (s lockedStore) Save(h *host.Host) error {
+	unlock, err := locks.writeStore(DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.API.Save(h)
+}
+
+func (s lockedStore) Remove(name string) error {
+	unlock, err := locks.writeStore(DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.API.Remove(name)
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	shortTimeout = 20 * time.Millisecond
+	longTimeout  = 2 * time.Second
+)
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		store:    newSharedLock(),
+		machines: make(map[string]*machineLock),
+	}
+}
+
+func TestSharedLockReadersShare(t *testing.T) {
+	l := newSharedLock()
+
+	release1, err := l.acquire(false, 0)
+	if err != nil {
+		t.Fatalf("First reader: %s", err)
+	}
+	release2, err := l.acquire(false, 0)
+	if err != nil {
+		t.Fatalf("Second reader: %s", err)
+	}
+
+	release1()
+	release2()
+
+	if l.readers != 0 || l.writer {
+		t.Errorf("Expected the lock to be free, got %d readers and writer=%v", l.readers, l.writer)
+	}
+}
+
+func TestSharedLockExclusion(t *testing.T) {
+	tests := []struct {
+		held      bool
+		requested bool
+	}{
+		{held: false, requested: true},
+		{held: true, requested: false},
+		{held: true, requested: true},
+	}
+
+	for _, test := range tests {
+		l := newSharedLock()
+
+		release, err := l.acquire(test.held, 0)
+		if err != nil {
+			t.Fatalf("exclusive=%v: %s", test.held, err)
+		}
+
+		if _, err := l.acquire(test.requested, 0); err != (ErrMachineLocked{}) {
+			t.Errorf("held exclusive=%v, requested exclusive=%v: expected ErrMachineLocked, got %v", test.held, test.requested, err)
+		}
+
+		release()
+
+		if _, err := l.acquire(test.requested, 0); err != nil {
+			t.Errorf("held exclusive=%v, requested exclusive=%v: expected the lock once released, got %s", test.held, test.requested, err)
+		}
+	}
+}
+
+func TestSharedLockTimeout(t *testing.T) {
+	l := newSharedLock()
+
+	if _, err := l.acquire(true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := l.acquire(false, shortTimeout)
+	if err != (ErrMachineLocked{}) {
+		t.Fatalf("Expected ErrMachineLocked, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < shortTimeout {
+		t.Errorf("Gave up after %s, before the %s timeout", elapsed, shortTimeout)
+	}
+}
+
+func TestSharedLockWaitsForRelease(t *testing.T) {
+	l := newSharedLock()
+
+	release, err := l.acquire(false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(true, longTimeout)
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("The writer didn't wait for the reader: %v", err)
+	case <-time.After(shortTimeout):
+	}
+
+	release()
+
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the writer to get the lock once released, got %s", err)
+	}
+}
+
+func TestLockMachineFailFast(t *testing.T) {
+	l := newLockManager()
+
+	release, err := l.lockMachine("dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := l.lockMachine("dev", 0); err != (ErrMachineLocked{"dev"}) {
+		t.Errorf("Expected ErrMachineLocked for dev, got %v", err)
+	}
+
+	other, err := l.lockMachine("other", 0)
+	if err != nil {
+		t.Errorf("Expected another machine to be lockable, got %s", err)
+	} else {
+		other()
+	}
+}
+
+func TestLockMachineTimeout(t *testing.T) {
+	l := newLockManager()
+
+	release, err := l.lockMachine("dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	start := time.Now()
+	if _, err := l.lockMachine("dev", shortTimeout); err != (ErrMachineLocked{"dev"}) {
+		t.Fatalf("Expected ErrMachineLocked for dev, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < shortTimeout {
+		t.Errorf("Gave up after %s, before the %s timeout", elapsed, shortTimeout)
+	}
+}
+
+func TestLockMachineDropsIdleLocks(t *testing.T) {
+	l := newLockManager()
+
+	release, err := l.lockMachine("dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A waiter that gives up must not leave its reference behind
+	if _, err := l.lockMachine("dev", 0); err == nil {
+		t.Fatal("Expected the machine to be locked")
+	}
+	if refs := l.machines["dev"].refs; refs != 1 {
+		t.Errorf("Expected 1 reference, got %d", refs)
+	}
+
+	release()
+
+	if len(l.machines) != 0 {
+		t.Errorf("Expected no lock left, got %d", len(l.machines))
+	}
+}
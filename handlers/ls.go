@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/docker/machine/libmachine"
-	"github.com/docker/machine/libmachine/persist"
 
 	"github.com/docker/machine/commands"
 	"github.com/docker/machine/libmachine/drivers"
@@ -18,25 +17,69 @@ import (
 	"github.com/docker/machine/libmachine/swarm"
 )
 
-const (
-	lsTimeoutDuration = 10 * time.Second
-)
+// QueryTimeout is how long a driver is given to tell the state of a machine.
+var QueryTimeout = 10 * time.Second
 
-// Ls lists all Docker Machines.
+// Ls lists all Docker Machines. The list comes from the inventory refreshed in
+// the background, unless fresh=true is passed.
 func Ls(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
-	hostList, hostInError, err := persist.LoadAllHosts(api)
+	filters, err := parseFilters(form)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := parseFields(form)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := lockTimeout(form)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := formValue(form, "fresh") == "true"
+
+	// PERFORMANCE: Don't ask each machine for its docker version
+	// if it's not needed. The versions already known are kept.
+	withDockerVersion := fields == nil || containsString(fields, "DockerVersion")
+
+	items, err := listItems(api, timeout, fresh, withDockerVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	items = filters.apply(items)
+	if err := sortItems(items, formValue(form, "sort")); err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		return items, nil
+	}
+
+	return selectFields(items, fields)
+}
+
+func listItems(api libmachine.API, lockTimeout time.Duration, fresh bool, withDockerVersion bool) ([]MachineItem, error) {
+	var err error
+	if fresh || !machineWatcher.ready() {
+		err = machineWatcher.refreshAll(api, lockTimeout, withDockerVersion)
+	} else {
+		err = machineWatcher.refreshStale(api, withDockerVersion)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return listHosts(hostList, hostInError), nil
+	return machineWatcher.list(), nil
 }
 
 // TODO: export this in docker-machine
-func listHosts(validHosts []*host.Host, hostsInError map[string]error) []commands.HostListItem {
+func listHosts(validHosts []*host.Host, hostsInError map[string]error, withDockerVersion bool) []commands.HostListItem {
 	itemChan := make(chan commands.HostListItem)
 	for _, h := range validHosts {
-		go getHostItem(h, itemChan)
+		go getHostItem(h, itemChan, withDockerVersion)
 	}
 
 	hosts := []commands.HostListItem{}
@@ -58,15 +101,16 @@ func listHosts(validHosts []*host.Host, hostsInError map[string]error) []command
 	return hosts
 }
 
-func getHostItem(h *host.Host, itemChan chan<- commands.HostListItem) {
-	hosts := make(chan commands.HostListItem)
+func getHostItem(h *host.Host, itemChan chan<- commands.HostListItem, withDockerVersion bool) {
+	// Buffered so that a query that times out can still complete and exit
+	hosts := make(chan commands.HostListItem, 1)
 
-	go attemptGetHostItem(h, hosts)
+	go attemptGetHostItem(h, hosts, withDockerVersion)
 
 	select {
 	case hli := <-hosts:
 		itemChan <- hli
-	case <-time.After(lsTimeoutDuration):
+	case <-time.After(QueryTimeout):
 		itemChan <- commands.HostListItem{
 			Name:       h.Name,
 			DriverName: h.Driver.DriverName(),
@@ -78,7 +122,7 @@ func getHostItem(h *host.Host, itemChan chan<- commands.HostListItem) {
 // PERFORMANCE: The code of this function is complicated because we try
 // to call the underlying drivers as less as possible to get the information
 // we need.
-func attemptGetHostItem(h *host.Host, stateQueryChan chan<- commands.HostListItem) {
+func attemptGetHostItem(h *host.Host, stateQueryChan chan<- commands.HostListItem, withDockerVersion bool) {
 	url := ""
 	currentState := state.None
 	dockerVersion := "Unknown"
@@ -98,7 +142,7 @@ func attemptGetHostItem(h *host.Host, stateQueryChan chan<- commands.HostListIte
 		currentState, _ = h.Driver.GetState()
 	}
 
-	if err == nil && url != "" {
+	if err == nil && url != "" && withDockerVersion {
 		// PERFORMANCE: Reuse the url instead of asking the host again.
 		// This reduces the number of calls to the drivers
 		dockerHost := &mcndockerclient.RemoteDocker{
@@ -1,59 +1,121 @@
 package handlers
 
 import (
-	"encoding/json"
-
-	"sync"
+	"io"
 
 	"github.com/docker/machine/commands/mcndirs"
 	"github.com/docker/machine/libmachine"
 )
 
-// libmachine is not thread safe, specially when it saves machines to the disk
-var globalMutex = &sync.Mutex{}
-
 type Success struct {
 	Action string
 	Name   string
 }
 
+// Streamer is a result that is written as is instead of being encoded to json.
+type Streamer interface {
+	ContentType() string
+	Stream(w io.Writer) error
+}
+
 type Mapping struct {
 	Method  string
 	Url     string
 	Handler Handler
+	// Background mappings always run as jobs
+	Background bool
+	// Role overrides the role required to call the mapping
+	Role string
 }
 
 func NewMapping(method string, url string, handler HandlerFunc) Mapping {
-	return Mapping{method, url, handler}
+	return Mapping{Method: method, Url: url, Handler: handler}
+}
+
+func NewBodyMapping(method string, url string, handler BodyHandlerFunc) Mapping {
+	return Mapping{Method: method, Url: url, Handler: handler}
+}
+
+func NewStatelessMapping(method string, url string, handler StatelessFunc) Mapping {
+	return Mapping{Method: method, Url: url, Handler: handler}
+}
+
+// InBackground makes a mapping always run as a job, for actions that take minutes.
+func (m Mapping) InBackground() Mapping {
+	m.Background = true
+	return m
+}
+
+// WithRole changes the role required to call a mapping.
+func (m Mapping) WithRole(role string) Mapping {
+	m.Role = role
+	return m
+}
+
+// RequiredRole is the role required to call a mapping. By default, reading
+// requires the read-only role and changing the machines requires the operator role.
+func (m Mapping) RequiredRole() string {
+	if m.Role != "" {
+		return m.Role
+	}
+
+	if m.IsMutating() {
+		return RoleOperator
+	}
+
+	return RoleReadOnly
+}
+
+// IsMutating tells if the mapping changes the state of the machines.
+func (m Mapping) IsMutating() bool {
+	return m.Method != "GET"
+}
+
+// CanRunAsJob tells if the mapping can run in the background. Stateless
+// mappings, such as the jobs themselves, always answer right away.
+func (m Mapping) CanRunAsJob() bool {
+	_, stateless := m.Handler.(StatelessFunc)
+	return m.IsMutating() && !stateless
 }
 
 type Handler interface {
-	Handle(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error)
+	Handle(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error)
 }
 
 type HandlerFunc func(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error)
 
-func (f HandlerFunc) Handle(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+func (f HandlerFunc) Handle(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
 	return f(api, args, form)
 }
 
-func WithApi(handler Handler, args map[string]string, form map[string][]string) func() (interface{}, error) {
-	return func() (interface{}, error) {
-		globalMutex.Lock()
-		defer globalMutex.Unlock()
+// BodyHandlerFunc is a handler that reads the body of the request.
+type BodyHandlerFunc func(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error)
 
-		api := libmachine.NewClient(mcndirs.GetBaseDir(), mcndirs.GetMachineCertDir())
-		defer api.Close()
+func (f BodyHandlerFunc) Handle(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
+	return f(api, args, form, body)
+}
 
-		return handler.Handle(api, args, form)
-	}
+// StatelessFunc is a handler that doesn't need to access the machines.
+type StatelessFunc func(args map[string]string, form map[string][]string) (interface{}, error)
+
+func (f StatelessFunc) Handle(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
+	return f(args, form)
 }
 
-func ToJson(handler func() (interface{}, error)) ([]byte, error) {
-	body, err := handler()
-	if err != nil {
-		return nil, err
+func WithApi(handler Handler, args map[string]string, form map[string][]string, body io.Reader) func() (interface{}, error) {
+	if f, stateless := handler.(StatelessFunc); stateless {
+		return func() (interface{}, error) {
+			return f(args, form)
+		}
 	}
 
-	return json.Marshal(body)
+	return func() (interface{}, error) {
+		api := libmachine.NewClient(mcndirs.GetBaseDir(), mcndirs.GetMachineCertDir())
+		defer api.Close()
+
+		// The request or the job running the handler is the operation
+		defer operations.use(api)()
+
+		return handler.Handle(lockedStore{api}, args, form, body)
+	}
 }
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/commands"
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+)
+
+// Profile holds a driver and the default values used to create machines.
+type Profile struct {
+	Name    string
+	Driver  string
+	Options map[string][]string
+}
+
+// Parameters of the daemon itself that are not saved in profiles.
+var reservedParameters = []string{"driver", "profile", "async", "lockTimeout", "dryRun", "onFailure"}
+
+func profilesDir() string {
+	return filepath.Join(mcndirs.GetBaseDir(), "daemon", "profiles")
+}
+
+// ListProfiles lists the saved profiles.
+func ListProfiles(args map[string]string, form map[string][]string) (interface{}, error) {
+	files, err := ioutil.ReadDir(profilesDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+
+	profiles := []Profile{}
+	for _, name := range names {
+		profile, err := loadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, *profile)
+	}
+
+	return profiles, nil
+}
+
+// InspectProfile shows a profile.
+func InspectProfile(args map[string]string, form map[string][]string) (interface{}, error) {
+	name, err := profileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadProfile(name)
+}
+
+// SaveProfile creates or replaces a profile, after checking its values
+// against the create flags of its driver.
+func SaveProfile(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	name, err := profileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := formValue(form, "driver")
+	if driver == "" {
+		return nil, errRequireDriverName
+	}
+
+	mcnflags, err := driverCreateFlags(api, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := flagKinds(mcnflags, commands.SharedCreateFlags)
+	options := make(map[string][]string)
+
+	for key, values := range form {
+		if containsString(reservedParameters, key) {
+			continue
+		}
+
+		kind, present := kinds[key]
+		if !present {
+			return nil, errInvalidParameter{key, strings.Join(values, ","), errUnknownField}
+		}
+
+		for _, value := range values {
+			if err := checkFlagValue(kind, value); err != nil {
+				return nil, errInvalidParameter{key, value, err}
+			}
+		}
+
+		options[key] = values
+	}
+
+	profile := Profile{
+		Name:    name,
+		Driver:  driver,
+		Options: options,
+	}
+
+	data, err := json.MarshalIndent(profile, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(profilesDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(profilesDir(), name+".json"), data, 0600); err != nil {
+		return nil, err
+	}
+
+	return Success{"saved", name}, nil
+}
+
+// RemoveProfile removes a profile.
+func RemoveProfile(args map[string]string, form map[string][]string) (interface{}, error) {
+	name, err := profileName(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(filepath.Join(profilesDir(), name+".json")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errProfileNotFound{name}
+		}
+		return nil, err
+	}
+
+	return Success{"removed", name}, nil
+}
+
+func profileName(args map[string]string) (string, error) {
+	name, present := args["profile"]
+	if !present {
+		return "", errRequireProfileName
+	}
+
+	// Profile names follow the same rules as machine names
+	if !host.ValidateHostName(name) {
+		return "", errInvalidParameter{"profile", name, errInvalidProfileName}
+	}
+
+	return name, nil
+}
+
+func loadProfile(name string) (*Profile, error) {
+	data, err := ioutil.ReadFile(filepath.Join(profilesDir(), name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errProfileNotFound{name}
+		}
+		return nil, err
+	}
+
+	profile := &Profile{}
+	if err := json.Unmarshal(data, profile); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// applyProfile merges the values of a profile with the values of a form.
+// Values of the form take precedence.
+func applyProfile(profile *Profile, form map[string][]string) map[string][]string {
+	merged := make(map[string][]string)
+	for key, values := range profile.Options {
+		merged[key] = values
+	}
+	for key, values := range form {
+		merged[key] = values
+	}
+
+	if _, present := merged["driver"]; !present {
+		merged["driver"] = []string{profile.Driver}
+	}
+
+	return merged
+}
+
+func checkFlagValue(kind string, value string) error {
+	switch kind {
+	case flagInt:
+		_, err := strconv.Atoi(value)
+		return err
+	case flagBool:
+		_, err := parseBool(value)
+		return err
+	}
+
+	return nil
+}
@@ -2,7 +2,14 @@ package handlers
 
 import "github.com/docker/machine/libmachine"
 
-// Remove removes a Docker Machine
+// Forced is the result of an action that went on despite an error.
+type Forced struct {
+	Success
+	Warning string
+}
+
+// Remove removes a Docker Machine. With force=true, the machine is removed
+// from the store even if the driver fails to remove it.
 func Remove(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
 	name, present := args["name"]
 	if !present {
@@ -14,18 +21,23 @@ func Remove(api libmachine.API, args map[string]string, form map[string][]string
 		return Success{"removed", name}, nil
 	}
 
-	currentHost, err := api.Load(name)
-	if err != nil {
-		return nil, err
-	}
+	force := formValue(form, "force") == "true"
 
-	if err := currentHost.Driver.Remove(); err != nil {
-		return nil, err
+	currentHost, driverErr := api.Load(name)
+	if driverErr == nil {
+		driverErr = currentHost.Driver.Remove()
+	}
+	if driverErr != nil && !force {
+		return nil, driverErr
 	}
 
 	if err := api.Remove(name); err != nil {
 		return nil, err
 	}
 
+	if driverErr != nil {
+		return Forced{Success{"removed", name}, driverErr.Error()}, nil
+	}
+
 	return Success{"removed", name}, nil
 }
@@ -0,0 +1,11 @@
+package handlers
+
+// Besides the variables of the path, the handler args hold a few things about
+// the request, under keys that no route uses.
+const (
+	// CallerRole is the key of the role of the caller.
+	CallerRole = "callerRole"
+
+	// ContentType is the key of the content type of the body.
+	ContentType = "contentType"
+)
@@ -0,0 +1,25 @@
+package handlers
+
+// Roles given to the clients, from the least to the most privileged.
+const (
+	RoleReadOnly = "read-only"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// IsRole tells if a role exists.
+func IsRole(role string) bool {
+	_, present := roleLevels[role]
+	return present
+}
+
+// HasRole tells if a role is at least as privileged as the required role.
+func HasRole(role string, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+)
+
+const (
+	drainInterval = 100 * time.Millisecond
+)
+
+// Operation is an action in progress on the machines.
+type Operation struct {
+	Action string
+	Name   string
+	Start  time.Time
+}
+
+func (o Operation) String() string {
+	if o.Name == "" {
+		return fmt.Sprintf("%s, started %s ago", o.Action, time.Since(o.Start))
+	}
+
+	return fmt.Sprintf("%s on %q, started %s ago", o.Action, o.Name, time.Since(o.Start))
+}
+
+// operationList tracks the requests, jobs and libmachine clients in use, so
+// that the daemon can wait for them before exiting.
+type operationList struct {
+	sync.Mutex
+	shuttingDown bool
+	lastID       int64
+	running      map[int64]Operation
+	clients      map[libmachine.API]bool
+}
+
+var operations = &operationList{
+	running: make(map[int64]Operation),
+	clients: make(map[libmachine.API]bool),
+}
+
+// BeginOperation registers an operation, such as a whole http request or a
+// job. It returns the function to call when the operation is over.
+func BeginOperation(action string, name string) (func(), error) {
+	return operations.begin(action, name)
+}
+
+// begin registers an operation. It returns the function to call when the
+// operation is over. No operation can begin once the daemon is shutting down.
+func (l *operationList) begin(action string, name string) (func(), error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.shuttingDown {
+		return nil, errShuttingDown
+	}
+
+	l.lastID++
+	id := l.lastID
+	l.running[id] = Operation{action, name, time.Now()}
+
+	return func() {
+		l.Lock()
+		defer l.Unlock()
+
+		delete(l.running, id)
+	}, nil
+}
+
+// use registers a libmachine client used by an operation, to be closed if the
+// operation is abandoned. It returns the function to call when the client is
+// no longer used. The client is not an operation of its own: a request that
+// acts on several machines is listed once.
+func (l *operationList) use(api libmachine.API) func() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.clients[api] = true
+
+	return func() {
+		l.Lock()
+		defer l.Unlock()
+
+		delete(l.clients, api)
+	}
+}
+
+func (l *operationList) count() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return len(l.running)
+}
+
+// Drain refuses new operations, ends the event streams and waits for at most
+// timeout for the running operations to complete. The operations still running
+// after that are abandoned: their libmachine clients are closed, which stops
+// the driver plugins.
+func Drain(timeout time.Duration) []Operation {
+	operations.Lock()
+	operations.shuttingDown = true
+	operations.Unlock()
+
+	machineWatcher.closeSubscribers()
+
+	deadline := time.Now().Add(timeout)
+	for operations.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainInterval)
+	}
+
+	operations.Lock()
+	defer operations.Unlock()
+
+	abandoned := []Operation{}
+	for id, operation := range operations.running {
+		abandoned = append(abandoned, operation)
+		delete(operations.running, id)
+	}
+	for api := range operations.clients {
+		api.Close()
+		delete(operations.clients, api)
+	}
+	sort.Sort(byOperationStart(abandoned))
+
+	return abandoned
+}
+
+type byOperationStart []Operation
+
+func (operations byOperationStart) Len() int {
+	return len(operations)
+}
+
+func (operations byOperationStart) Swap(i, j int) {
+	operations[i], operations[j] = operations[j], operations[i]
+}
+
+func (operations byOperationStart) Less(i, j int) bool {
+	return operations[i].Start.Before(operations[j].Start)
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/ssh"
+	"github.com/docker/machine/libmachine/state"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// Same as the ConnectTimeout of the external ssh client of libmachine
+	sshDialTimeout = 10 * time.Second
+)
+
+// SSHResult is the outcome of a command run inside a machine.
+type SSHResult struct {
+	Stdout     string
+	Stderr     string
+	ExitStatus int
+}
+
+// SSHChunk is a piece of the output of a streamed command. The last chunk
+// carries the exit status or the error.
+type SSHChunk struct {
+	Stream     string `json:",omitempty"`
+	Data       string `json:",omitempty"`
+	ExitStatus *int   `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+// SSH runs a command inside a Docker Machine
+func SSH(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	command := formValue(form, "command")
+	if command == "" {
+		return nil, errRequireCommand
+	}
+
+	timeout, err := durationValue(form, "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	stream := formValue(form, "stream") == "true"
+	if stream && formValue(form, "async") == "true" {
+		return nil, errInvalidParameter{"stream", "true", errStreamInBackground}
+	}
+
+	h, err := loadRunningMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := newSSHSession(h, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream {
+		return &sshStream{session, command, timeout}, nil
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	exitStatus, err := runSSH(session, command, nil, &stdout, &stderr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return SSHResult{stdout.String(), stderr.String(), exitStatus}, nil
+}
+
+func loadRunningMachine(api libmachine.API, args map[string]string) (*host.Host, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	currentState, err := h.Driver.GetState()
+	if err != nil {
+		return nil, err
+	}
+
+	if currentState != state.Running {
+		return nil, errMachineNotRunning{h.Name, currentState}
+	}
+
+	return h, nil
+}
+
+// sshSession runs a command with the ssh client libmachine is configured
+// to use, native or external. Unlike ssh.Client, it can be interrupted.
+type sshSession interface {
+	Start(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
+	Wait() (int, error)
+	Close() error
+}
+
+// newSSHSession connects to a machine. The native client gives up
+// connecting after timeout, or after sshDialTimeout if there's none.
+func newSSHSession(h *host.Host, timeout time.Duration) (sshSession, error) {
+	client, err := h.CreateSSHClient()
+	if err != nil {
+		return nil, err
+	}
+
+	switch client := client.(type) {
+	case *ssh.NativeClient:
+		if timeout <= 0 {
+			timeout = sshDialTimeout
+		}
+		return newNativeSession(client, timeout)
+	case *ssh.ExternalClient:
+		return &externalSession{client: client}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported ssh client %T", client)
+}
+
+type nativeSession struct {
+	session *gossh.Session
+	conn    *gossh.Client
+}
+
+func newNativeSession(client *ssh.NativeClient, timeout time.Duration) (*nativeSession, error) {
+	addr := net.JoinHostPort(client.Hostname, strconv.Itoa(client.Port))
+
+	netConn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errWithCause{"Error dialing ssh", err}
+	}
+
+	// The handshake must complete in time too
+	netConn.SetDeadline(time.Now().Add(timeout))
+
+	sshConn, channels, requests, err := gossh.NewClientConn(netConn, addr, &client.Config)
+	if err != nil {
+		netConn.Close()
+		return nil, errWithCause{"Error dialing ssh", err}
+	}
+	conn := gossh.NewClient(sshConn, channels, requests)
+
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, errWithCause{"Error opening ssh session", err}
+	}
+
+	netConn.SetDeadline(time.Time{})
+
+	return &nativeSession{session, conn}, nil
+}
+
+func (s *nativeSession) Start(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	s.session.Stdin = stdin
+	s.session.Stdout = stdout
+	s.session.Stderr = stderr
+
+	return s.session.Start(command)
+}
+
+func (s *nativeSession) Wait() (int, error) {
+	err := s.session.Wait()
+	if exitErr, ok := err.(*gossh.ExitError); ok {
+		return exitErr.ExitStatus(), nil
+	}
+
+	return 0, err
+}
+
+func (s *nativeSession) Close() error {
+	s.session.Close()
+	return s.conn.Close()
+}
+
+type externalSession struct {
+	client *ssh.ExternalClient
+	cmd    *exec.Cmd
+}
+
+func (s *externalSession) Start(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	args := append(append([]string{}, s.client.BaseArgs...), command)
+
+	s.cmd = exec.Command(s.client.BinaryPath, args...)
+	s.cmd.Stdin = stdin
+	s.cmd.Stdout = stdout
+	s.cmd.Stderr = stderr
+
+	return s.cmd.Start()
+}
+
+func (s *externalSession) Wait() (int, error) {
+	err := s.cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), nil
+		}
+	}
+
+	return 0, err
+}
+
+// Close kills the ssh process if it's still running.
+func (s *externalSession) Close() error {
+	if s.cmd != nil && s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+
+	return nil
+}
+
+// runSSH runs a command and waits for at most timeout for it to complete.
+// A zero timeout waits forever.
+func runSSH(s sshSession, command string, stdin io.Reader, stdout io.Writer, stderr io.Writer, timeout time.Duration) (int, error) {
+	if err := s.Start(command, stdin, stdout, stderr); err != nil {
+		return 0, err
+	}
+
+	type result struct {
+		status int
+		err    error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		status, err := s.Wait()
+		done <- result{status, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case result := <-done:
+		return result.status, result.err
+	case <-expired:
+		s.Close()
+		return 0, errCommandTimeout
+	}
+}
+
+// sshStream streams the output of a command, one json chunk per line.
+type sshStream struct {
+	session sshSession
+	command string
+	timeout time.Duration
+}
+
+func (s *sshStream) ContentType() string {
+	return "application/json"
+}
+
+func (s *sshStream) Stream(w io.Writer) error {
+	defer s.session.Close()
+
+	chunks := &chunkWriter{encoder: json.NewEncoder(w)}
+
+	status, err := runSSH(s.session, s.command, nil, chunks.stream("stdout"), chunks.stream("stderr"), s.timeout)
+	if err != nil {
+		return chunks.write(SSHChunk{Error: err.Error()})
+	}
+
+	return chunks.write(SSHChunk{ExitStatus: &status})
+}
+
+// chunkWriter encodes the output of stdout and stderr without mixing them.
+type chunkWriter struct {
+	sync.Mutex
+	encoder *json.Encoder
+}
+
+func (c *chunkWriter) write(chunk SSHChunk) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.encoder.Encode(chunk)
+}
+
+func (c *chunkWriter) stream(name string) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if err := c.write(SSHChunk{Stream: name, Data: string(p)}); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/state"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Minute
+	waitInterval       = 1 * time.Second
+)
+
+// MachineStatus is the state of a single machine.
+type MachineStatus struct {
+	Name  string
+	State string
+}
+
+// MachineIP is the ip of a single machine.
+type MachineIP struct {
+	Name string
+	IP   string
+}
+
+// MachineURL is the url of the Docker daemon of a single machine.
+type MachineURL struct {
+	Name string
+	URL  string
+}
+
+// Status shows the state of a Docker Machine, without querying the others.
+func Status(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, currentState, err := waitForMachine(api, args, form)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentState == state.None {
+		if currentState, err = h.Driver.GetState(); err != nil {
+			return nil, err
+		}
+	}
+
+	return MachineStatus{h.Name, currentState.String()}, nil
+}
+
+// IP shows the ip of a Docker Machine.
+func IP(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, _, err := waitForMachine(api, args, form)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := h.Driver.GetIP()
+	if err != nil {
+		return nil, err
+	}
+
+	return MachineIP{h.Name, ip}, nil
+}
+
+// URL shows the url of the Docker daemon of a Docker Machine.
+func URL(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, _, err := waitForMachine(api, args, form)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := h.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	return MachineURL{h.Name, url}, nil
+}
+
+// waitForMachine loads a machine and, with wait=<state>, polls its driver
+// until it reaches this state or the timeout expires. Without wait, the driver
+// is not queried and the state is state.None.
+func waitForMachine(api libmachine.API, args map[string]string, form map[string][]string) (*host.Host, state.State, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, state.None, err
+	}
+
+	desiredState := formValue(form, "wait")
+	if desiredState == "" {
+		return h, state.None, nil
+	}
+	if !isKnownState(desiredState) {
+		return nil, state.None, errInvalidParameter{"wait", desiredState, errUnknownState}
+	}
+
+	timeout, err := durationValue(form, "timeout")
+	if err != nil {
+		return nil, state.None, err
+	}
+	if timeout == 0 {
+		timeout = defaultWaitTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		currentState, err := h.Driver.GetState()
+		if err != nil {
+			return nil, state.None, err
+		}
+
+		if strings.EqualFold(currentState.String(), desiredState) {
+			return h, currentState, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, state.None, errStateTimeout{h.Name, desiredState, currentState}
+		}
+
+		time.Sleep(waitInterval)
+	}
+}
+
+func isKnownState(value string) bool {
+	for s := state.Running; s <= state.Timeout; s++ {
+		if strings.EqualFold(s.String(), value) {
+			return true
+		}
+	}
+
+	return false
+}
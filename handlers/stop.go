@@ -1,9 +1,6 @@
 package handlers
 
-import (
-	"github.com/docker/machine/libmachine"
-	"github.com/docker/machine/libmachine/mcnerror"
-)
+import "github.com/docker/machine/libmachine"
 
 // Stop stops a Docker Machine
 func Stop(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
@@ -13,7 +10,7 @@ func Stop(api libmachine.API, args map[string]string, form map[string][]string)
 	}
 
 	if err := h.Stop(); err != nil {
-		if _, alreadyStated := err.(mcnerror.ErrHostAlreadyInState); alreadyStated {
+		if err := checkAlreadyInState(err, form); err != nil {
 			return nil, err
 		}
 	}
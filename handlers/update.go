@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/engine"
+	"github.com/docker/machine/libmachine/swarm"
+)
+
+// UpdateRequest is the json document that lists the options to change.
+// Engine and Swarm mirror engine.Options and swarm.Options.
+type UpdateRequest struct {
+	Engine map[string]json.RawMessage
+	Swarm  map[string]json.RawMessage
+}
+
+// OptionChange is an option that was changed by an update.
+type OptionChange struct {
+	Option string
+	Old    interface{}
+	New    interface{}
+}
+
+// Updated is the result of an update.
+type Updated struct {
+	Success
+	Changes []OptionChange
+}
+
+// Update changes the engine and swarm options of a Docker Machine, provisions
+// the machine again to apply them and saves them.
+func Update(api libmachine.API, args map[string]string, form map[string][]string, body io.Reader) (interface{}, error) {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, errInvalidParameter{"body", string(content), err}
+	}
+
+	request := &UpdateRequest{}
+	if err := decodeFields(fields, request, ""); err != nil {
+		return nil, err
+	}
+	if len(request.Engine) == 0 && len(request.Swarm) == 0 {
+		return nil, errRequireOptions
+	}
+
+	h, err := loadRunningMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.HostOptions == nil {
+		return nil, errNoHostOptions
+	}
+	if h.HostOptions.EngineOptions == nil {
+		h.HostOptions.EngineOptions = &engine.Options{}
+	}
+	if h.HostOptions.SwarmOptions == nil {
+		h.HostOptions.SwarmOptions = &swarm.Options{}
+	}
+
+	oldEngine, err := toMap(h.HostOptions.EngineOptions)
+	if err != nil {
+		return nil, err
+	}
+	oldSwarm, err := toMap(h.HostOptions.SwarmOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decodeFields(request.Engine, h.HostOptions.EngineOptions, "engine."); err != nil {
+		return nil, err
+	}
+	if err := decodeFields(request.Swarm, h.HostOptions.SwarmOptions, "swarm."); err != nil {
+		return nil, err
+	}
+
+	newEngine, err := toMap(h.HostOptions.EngineOptions)
+	if err != nil {
+		return nil, err
+	}
+	newSwarm, err := toMap(h.HostOptions.SwarmOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := append(diffOptions(oldEngine, newEngine, "engine."), diffOptions(oldSwarm, newSwarm, "swarm.")...)
+	if len(changes) == 0 {
+		return Updated{Success{"updated", h.Name}, changes}, nil
+	}
+
+	// The options are saved only once they are applied
+	if err := h.Provision(); err != nil {
+		return nil, errWithCause{"Error provisioning the machine", err}
+	}
+
+	if err := api.Save(h); err != nil {
+		return nil, err
+	}
+
+	return Updated{Success{"updated", h.Name}, changes}, nil
+}
+
+func diffOptions(before, after map[string]interface{}, prefix string) []OptionChange {
+	keys := []string{}
+	for key := range after {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := []OptionChange{}
+	for _, key := range keys {
+		if !reflect.DeepEqual(before[key], after[key]) {
+			changes = append(changes, OptionChange{prefix + key, before[key], after[key]})
+		}
+	}
+
+	return changes
+}
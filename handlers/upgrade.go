@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/mcndockerclient"
+)
+
+// Upgraded is the result of an engine upgrade.
+type Upgraded struct {
+	Success
+	DockerVersion string
+}
+
+// Upgrade upgrades the Docker engine of a Docker Machine to the latest version.
+func Upgrade(api libmachine.API, args map[string]string, form map[string][]string) (interface{}, error) {
+	h, err := loadOneMachine(api, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Upgrade(); err != nil {
+		return nil, err
+	}
+
+	if err := api.Save(h); err != nil {
+		return nil, err
+	}
+
+	url, err := h.URL()
+	if err != nil {
+		return nil, err
+	}
+
+	dockerVersion, err := mcndockerclient.DockerVersion(&mcndockerclient.RemoteDocker{
+		HostURL:    url,
+		AuthOption: h.AuthOptions(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return Upgraded{Success{"upgraded", h.Name}, dockerVersion}, nil
+}
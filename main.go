@@ -1,30 +1,215 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/codegangsta/cli"
+	"github.com/dgageot/docker-machine-daemon/daemon"
+	"github.com/dgageot/docker-machine-daemon/daemon/certs"
 	"github.com/dgageot/docker-machine-daemon/daemon/http"
 	"github.com/dgageot/docker-machine-daemon/handlers"
+	"github.com/docker/machine/commands/mcndirs"
+	libmachinelog "github.com/docker/machine/libmachine/log"
+	libmachineversion "github.com/docker/machine/libmachine/version"
+	machineversion "github.com/docker/machine/version"
 )
 
 const (
-	httpPort = 8080
+	version = "0.1.0-dev"
 )
 
+var mappings = []handlers.Mapping{
+	handlers.NewMapping("GET", "/machine", handlers.Ls),
+	handlers.NewMapping("GET", "/machine/{name}", handlers.Inspect),
+	handlers.NewMapping("GET", "/machine/{name}/env", handlers.ShowEnv).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("GET", "/machine/{name}/status", handlers.Status),
+	handlers.NewMapping("GET", "/machine/{name}/ip", handlers.IP),
+	handlers.NewMapping("GET", "/machine/{name}/url", handlers.URL),
+	handlers.NewMapping("POST", "/machine/{name}/start", handlers.Start),
+	handlers.NewMapping("POST", "/machine/{name}/stop", handlers.Stop),
+	handlers.NewMapping("POST", "/machine/{name}/restart", handlers.Restart),
+	handlers.NewBodyMapping("PUT", "/machine/{name}", handlers.Create).WithRole(handlers.RoleAdmin),
+	handlers.NewBodyMapping("PATCH", "/machine/{name}", handlers.Update).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machine/{name}/kill", handlers.Kill),
+	handlers.NewMapping("POST", "/machine/{name}/upgrade", handlers.Upgrade).InBackground().WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machine/{name}/regenerate-certs", handlers.RegenerateCerts).InBackground().WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machine/{name}/remove", handlers.Remove).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machine/{name}/ssh", handlers.SSH).WithRole(handlers.RoleAdmin),
+	handlers.NewBodyMapping("PUT", "/machine/{name}/files", handlers.Upload).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("GET", "/machine/{name}/files", handlers.Download).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machines/{action:remove}", handlers.Bulk).WithRole(handlers.RoleAdmin),
+	handlers.NewMapping("POST", "/machines/{action}", handlers.Bulk),
+	handlers.NewStatelessMapping("GET", "/drivers", handlers.ListDrivers),
+	handlers.NewMapping("GET", "/drivers/{driver}", handlers.InspectDriver),
+	handlers.NewStatelessMapping("GET", "/profiles", handlers.ListProfiles),
+	handlers.NewStatelessMapping("GET", "/profiles/{profile}", handlers.InspectProfile),
+	handlers.NewMapping("PUT", "/profiles/{profile}", handlers.SaveProfile).WithRole(handlers.RoleAdmin),
+	handlers.NewStatelessMapping("DELETE", "/profiles/{profile}", handlers.RemoveProfile).WithRole(handlers.RoleAdmin),
+	handlers.NewStatelessMapping("GET", "/events", handlers.Events),
+	handlers.NewStatelessMapping("GET", "/jobs", handlers.ListJobs),
+	handlers.NewStatelessMapping("GET", "/jobs/{id}", handlers.InspectJob),
+	handlers.NewStatelessMapping("DELETE", "/jobs/{id}", handlers.RemoveJob),
+}
+
 func main() {
-	daemon := http.NewDaemon(
-		handlers.NewMapping("GET", "/machine", handlers.Ls),
-		handlers.NewMapping("POST", "/machine/{name}/start", handlers.Start),
-		handlers.NewMapping("POST", "/machine/{name}/stop", handlers.Stop),
-		handlers.NewMapping("POST", "/machine/{name}/restart", handlers.Restart),
-		handlers.NewMapping("PUT", "/machine/{name}", handlers.Create),
-		handlers.NewMapping("POST", "/machine/{name}/remove", handlers.Remove),
-	)
-
-	log.Printf("Listening on %d...\n", httpPort)
-	log.Printf(" - List the Docker Machines with: http GET http://localhost:%d/machine\n", httpPort)
-
-	if err := daemon.Start(httpPort); err != nil {
+	cli.VersionPrinter = printVersion
+
+	app := cli.NewApp()
+	app.Name = "docker-machine-daemon"
+	app.Usage = "An http server that exposes the Docker Machine actions"
+	app.Version = version
+	app.Flags = flags
+	app.Action = run
+	app.Commands = []cli.Command{
+		{
+			Name:      "client-cert",
+			Usage:     "Mint a client certificate signed by the CA of the daemon",
+			ArgsUsage: "NAME DIR",
+			Action:    clientCert,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(c *cli.Context) {
+	s, err := loadSettings(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.apply()
+
+	scheme := "http"
+	options := http.Options{
+		SocketMode:  s.SocketMode,
+		SocketGroup: s.SocketGroup,
+	}
+
+	if s.TLS {
+		certificates, err := certs.Bootstrap(s.TLSMachineCA, s.TLSHosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tlsConfig, err := certificates.TLSConfig(s.TLSVerify)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		scheme = "https"
+		options.TLSConfig = tlsConfig
+	}
+
+	if s.Tokens != "" {
+		tokens, err := http.LoadTokens(s.Tokens)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		options.Tokens = tokens
+	}
+
+	d := http.NewDaemon(options, mappings...)
+
+	handlers.StartWatcher(s.PollInterval)
+
+	for _, host := range s.Hosts {
+		proto, addr, _ := http.ParseAddress(host)
+
+		log.Printf("Listening on %s...\n", host)
+		if proto == "unix" {
+			log.Printf(" - List the Docker Machines with: curl --unix-socket %s http://localhost/machine\n", addr)
+		} else {
+			log.Printf(" - List the Docker Machines with: http GET %s://localhost:%s/machine\n", scheme, addr[strings.LastIndex(addr, ":")+1:])
+		}
+	}
+
+	for _, host := range s.unprotected() {
+		log.Printf("WARNING: %s is reachable from the network and anyone can control the machines. Use --tlsverify or --tokens.\n", host)
+	}
+
+	go stopOnSignal(d)
+
+	if err := d.Start(s.Hosts...); err != nil {
 		log.Fatal(err)
 	}
+
+	abandoned := handlers.Drain(s.ShutdownTimeout)
+	if len(abandoned) > 0 {
+		for _, operation := range abandoned {
+			log.Printf(" - Abandoned %s\n", operation)
+		}
+		log.Fatalf("Stopped with %d action(s) abandoned", len(abandoned))
+	}
+
+	log.Print("Stopped")
+}
+
+// stopOnSignal stops the daemon on SIGTERM or SIGINT. A second signal exits right away.
+func stopOnSignal(d daemon.Starter) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	<-signals
+	log.Print("Stopping, waiting for the actions in progress...")
+	if err := d.Stop(); err != nil {
+		log.Print(err)
+	}
+
+	<-signals
+	log.Print("Forced to stop")
+	os.Exit(1)
+}
+
+// clientCert mints a client certificate signed by the CA of the daemon.
+func clientCert(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		cli.ShowCommandHelp(c, "client-cert")
+		os.Exit(2)
+	}
+	name, dir := c.Args()[0], c.Args()[1]
+
+	s, err := loadSettings(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.apply()
+
+	certificates, err := certs.Bootstrap(s.TLSMachineCA, s.TLSHosts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := certificates.GenerateClientCert(name, dir); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Client certificate for %s written to %s\n", name, dir)
+}
+
+func printVersion(c *cli.Context) {
+	fmt.Printf("%s version %s\n", c.App.Name, c.App.Version)
+	fmt.Printf("libmachine version %s, api version %d\n", machineversion.FullVersion(), libmachineversion.APIVersion)
+}
+
+// apply configures libmachine and the handlers.
+func (s *settings) apply() {
+	if s.StoragePath != "" {
+		mcndirs.BaseDir = s.StoragePath
+	}
+
+	libmachinelog.SetDebug(s.LogLevel == "debug")
+
+	handlers.DefaultLockTimeout = s.LockTimeout
+	handlers.QueryTimeout = s.QueryTimeout
+	handlers.MachineCAInUse = s.TLS && s.TLSMachineCA
 }